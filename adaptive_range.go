@@ -0,0 +1,141 @@
+package main
+
+import "errors"
+
+// Blockscout's getLogs endpoint returns at most this many results; a page of
+// this size may have been truncated
+const BlockscoutLogsCap = 1000
+
+// A chunk returning fewer logs than this is considered quiet and the block
+// range is allowed to grow again
+const quietChunkLogs = BlockscoutLogsCap / 4
+
+// RangeStats records the effective block range sizes used by a scan
+type RangeStats struct {
+	Requests       int    `json:"requests"`
+	Splits         int    `json:"splits"`
+	TruncatedPages int    `json:"truncated_pages"`
+	MinRange       uint64 `json:"min_range"`
+	MaxRange       uint64 `json:"max_range"`
+	TotalBlocks    uint64 `json:"total_blocks"`
+}
+
+// record adds a successfully fetched sub-range of size blocks
+func (s *RangeStats) record(size uint64) {
+	s.Requests++
+	s.TotalBlocks += size
+	if s.MinRange == 0 || size < s.MinRange {
+		s.MinRange = size
+	}
+	if size > s.MaxRange {
+		s.MaxRange = size
+	}
+}
+
+// merge adds the ranges recorded in other
+func (s *RangeStats) merge(other RangeStats) {
+	s.Requests += other.Requests
+	s.Splits += other.Splits
+	s.TruncatedPages += other.TruncatedPages
+	s.TotalBlocks += other.TotalBlocks
+	if other.MinRange != 0 && (s.MinRange == 0 || other.MinRange < s.MinRange) {
+		s.MinRange = other.MinRange
+	}
+	if other.MaxRange > s.MaxRange {
+		s.MaxRange = other.MaxRange
+	}
+}
+
+// AverageRange returns the mean effective range size
+func (s RangeStats) AverageRange() uint64 {
+	if s.Requests == 0 {
+		return 0
+	}
+	return s.TotalBlocks / uint64(s.Requests)
+}
+
+// adaptiveRange picks the size of the next block range: it shrinks to the
+// sub-range size that fit under the result cap, and doubles again (up to the
+// configured maximum) while chunks stay quiet
+type adaptiveRange struct {
+	size uint64
+	max  uint64
+}
+
+func newAdaptiveRange(max uint64) *adaptiveRange {
+	return &adaptiveRange{size: max, max: max}
+}
+
+// end returns the last block of the range starting at from, capped at endBlock
+func (r *adaptiveRange) end(from, endBlock uint64) uint64 {
+	to := from + r.size - 1
+	if to > endBlock || to < from {
+		to = endBlock
+	}
+	return to
+}
+
+// observe adjusts the range size after a chunk that returned logCount logs;
+// split reports whether the chunk had to be bisected, down to sub-ranges of
+// smallest blocks
+func (r *adaptiveRange) observe(logCount int, smallest uint64, split bool) {
+	if split {
+		r.size = smallest
+		return
+	}
+	if logCount < quietChunkLogs && r.size < r.max {
+		r.size *= 2
+		if r.size > r.max {
+			r.size = r.max
+		}
+	}
+}
+
+// fetchLogsAdaptive fetches logs for [fromBlock, toBlock], bisecting the
+// range recursively whenever a page hits the source's result cap (or the
+// source rejects the range for returning too many results) so that no
+// sub-range is truncated.
+// It returns the logs and the smallest sub-range size that had to be used.
+func fetchLogsAdaptive(source ChainSource, topics []string, fromBlock, toBlock uint64, targetAddresses []string, stats *RangeStats) ([]LogEntry, uint64, error) {
+	logs, err := source.FetchLogs(topics, fromBlock, toBlock, targetAddresses)
+	limitExceeded := errors.Is(err, ErrLogLimitExceeded)
+	if err != nil && (!limitExceeded || fromBlock == toBlock) {
+		return nil, 0, err
+	}
+
+	size := toBlock - fromBlock + 1
+	logsCap := source.LogsCap()
+	if !limitExceeded && (logsCap == 0 || len(logs) < logsCap) {
+		stats.record(size)
+		return logs, size, nil
+	}
+
+	if fromBlock == toBlock {
+		// A single block can't be split any further
+		stats.record(size)
+		stats.TruncatedPages++
+		httpLogger.Warn("Single block hit the result cap; results may be truncated",
+			"from_block", fromBlock, "to_block", toBlock, "logs", len(logs), "backend", source.Name())
+		return logs, size, nil
+	}
+
+	stats.Splits++
+	mid := fromBlock + (toBlock-fromBlock)/2
+	httpLogger.Debug("Range hit the result limit, splitting it",
+		"from_block", fromBlock, "to_block", toBlock, "backend", source.Name(), "split_at", mid)
+
+	left, leftSmallest, err := fetchLogsAdaptive(source, topics, fromBlock, mid, targetAddresses, stats)
+	if err != nil {
+		return nil, 0, err
+	}
+	right, rightSmallest, err := fetchLogsAdaptive(source, topics, mid+1, toBlock, targetAddresses, stats)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	smallest := leftSmallest
+	if rightSmallest < smallest {
+		smallest = rightSmallest
+	}
+	return append(left, right...), smallest, nil
+}
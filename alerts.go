@@ -0,0 +1,719 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/smtp"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Supported alert sink types
+const (
+	SinkWebhook = "webhook"
+	SinkSlack   = "slack"
+	SinkEmail   = "email"
+)
+
+// Defaults of the notifier
+const (
+	DefaultAlertDeadLetter = "cpimp_alerts_dead_letter.ndjson"
+	DefaultAlertSentLog    = "cpimp_alerts_sent.log"
+	DefaultSMTPPort        = 587
+
+	alertMaxAttempts    = 3
+	alertRetryDelay     = 2 * time.Second
+	alertSinkCooldown   = time.Minute
+	alertRequestTimeout = 10 * time.Second
+	alertQueueSize      = 256
+)
+
+// alertSignatureHeader carries the webhook body's HMAC-SHA256 signature,
+// "sha256=<hex>"
+const alertSignatureHeader = "X-CPIMP-Signature"
+
+// AlertConfig configures the sinks notified of new findings (the alerts
+// section of a scan config file)
+type AlertConfig struct {
+	Sinks []AlertSinkConfig `json:"sinks,omitempty" yaml:"sinks,omitempty"`
+
+	// NDJSON file receiving the alerts a sink still refused after every
+	// retry ("" = DefaultAlertDeadLetter)
+	DeadLetter string `json:"dead_letter,omitempty" yaml:"dead_letter,omitempty"`
+
+	// File recording the alerts already delivered, so a transaction is
+	// alerted once per sink across runs ("" = DefaultAlertSentLog)
+	SentLog string `json:"sent_log,omitempty" yaml:"sent_log,omitempty"`
+}
+
+// AlertSinkConfig is one alert destination
+type AlertSinkConfig struct {
+	// Name identifies the sink in the sent log and dead letters
+	// ("" = its type, numbered when several sinks share it)
+	Name string `json:"name,omitempty" yaml:"name,omitempty"`
+	Type string `json:"type" yaml:"type"`
+
+	// Lowest severity of the findings sent to the sink ("" = every finding)
+	MinSeverity string `json:"min_severity,omitempty" yaml:"min_severity,omitempty"`
+
+	// webhook and slack: the URL alerts are POSTed to
+	URL string `json:"url,omitempty" yaml:"url,omitempty"`
+
+	// webhook: key of the body's HMAC-SHA256 signature (SecretEnv names an
+	// environment variable holding it)
+	Secret    string `json:"secret,omitempty" yaml:"secret,omitempty"`
+	SecretEnv string `json:"secret_env,omitempty" yaml:"secret_env,omitempty"`
+
+	// email: SMTP server and optional credentials (PasswordEnv names an
+	// environment variable holding the password)
+	SMTPHost    string   `json:"smtp_host,omitempty" yaml:"smtp_host,omitempty"`
+	SMTPPort    int      `json:"smtp_port,omitempty" yaml:"smtp_port,omitempty"`
+	Username    string   `json:"username,omitempty" yaml:"username,omitempty"`
+	Password    string   `json:"password,omitempty" yaml:"password,omitempty"`
+	PasswordEnv string   `json:"password_env,omitempty" yaml:"password_env,omitempty"`
+	From        string   `json:"from,omitempty" yaml:"from,omitempty"`
+	To          []string `json:"to,omitempty" yaml:"to,omitempty"`
+}
+
+// finalize names unnamed sinks and fills in the default files and SMTP port
+func (c *AlertConfig) finalize() {
+	if len(c.Sinks) == 0 {
+		return
+	}
+	if c.DeadLetter == "" {
+		c.DeadLetter = DefaultAlertDeadLetter
+	}
+	if c.SentLog == "" {
+		c.SentLog = DefaultAlertSentLog
+	}
+	count := make(map[string]int)
+	for i := range c.Sinks {
+		sink := &c.Sinks[i]
+		sink.Type = strings.ToLower(sink.Type)
+		sink.MinSeverity = strings.ToLower(sink.MinSeverity)
+		if sink.Name == "" {
+			count[sink.Type]++
+			sink.Name = sink.Type
+			if count[sink.Type] > 1 {
+				sink.Name = fmt.Sprintf("%s-%d", sink.Type, count[sink.Type])
+			}
+		}
+		if sink.Type == SinkEmail && sink.SMTPPort == 0 {
+			sink.SMTPPort = DefaultSMTPPort
+		}
+	}
+}
+
+// validate checks that every sink has the settings its type needs
+func (c AlertConfig) validate() error {
+	names := make(map[string]bool)
+	for i, sink := range c.Sinks {
+		if names[sink.Name] {
+			return fmt.Errorf("alert sink %d: duplicate name %q", i+1, sink.Name)
+		}
+		names[sink.Name] = true
+		if sink.MinSeverity != "" && severityRank(sink.MinSeverity) == 0 {
+			return fmt.Errorf("alert sink %q: unknown min_severity %q (use low, medium or high)", sink.Name, sink.MinSeverity)
+		}
+		switch sink.Type {
+		case SinkWebhook, SinkSlack:
+			if sink.URL == "" {
+				return fmt.Errorf("alert sink %q: %s sinks need a url", sink.Name, sink.Type)
+			}
+		case SinkEmail:
+			if sink.SMTPHost == "" || sink.From == "" || len(sink.To) == 0 {
+				return fmt.Errorf("alert sink %q: email sinks need smtp_host, from and to", sink.Name)
+			}
+		default:
+			return fmt.Errorf("alert sink %d: unknown type %q (use webhook, slack or email)", i+1, sink.Type)
+		}
+	}
+	return nil
+}
+
+// redacted returns the config with secrets, passwords and sink URLs (which
+// often embed a token) hidden, for printing
+func (c AlertConfig) redacted() AlertConfig {
+	sinks := make([]AlertSinkConfig, len(c.Sinks))
+	for i, sink := range c.Sinks {
+		if sink.URL != "" {
+			sink.URL = alertTarget(sink.URL) + "/<redacted>"
+		}
+		if sink.Secret != "" {
+			sink.Secret = "<redacted>"
+		}
+		if sink.Password != "" {
+			sink.Password = "<redacted>"
+		}
+		sinks[i] = sink
+	}
+	c.Sinks = sinks
+	return c
+}
+
+// severityRank orders severities; 0 for an unknown one
+func severityRank(severity string) int {
+	switch severity {
+	case SeverityLow:
+		return 1
+	case SeverityMedium:
+		return 2
+	case SeverityHigh:
+		return 3
+	}
+	return 0
+}
+
+// AlertSink delivers a finding to one destination
+type AlertSink interface {
+	Send(finding Finding) error
+}
+
+// newAlertSink creates the sink described by config
+func newAlertSink(config AlertSinkConfig) AlertSink {
+	switch config.Type {
+	case SinkWebhook:
+		secret := config.Secret
+		if config.SecretEnv != "" {
+			secret = os.Getenv(config.SecretEnv)
+		}
+		return &webhookSink{url: config.URL, secret: secret}
+	case SinkSlack:
+		return &slackSink{url: config.URL}
+	default:
+		password := config.Password
+		if config.PasswordEnv != "" {
+			password = os.Getenv(config.PasswordEnv)
+		}
+		return &emailSink{config: config, password: password}
+	}
+}
+
+// alertHTTP sends the webhook and Slack alerts. They don't go through the
+// scan's APIClient: the notifier retries them itself, and they don't count against
+// the chain API rate limit.
+var alertHTTP = &http.Client{Timeout: alertRequestTimeout}
+
+// alertTarget returns the scheme and host of a sink URL, for errors and logs
+func alertTarget(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return "sink"
+	}
+	return u.Scheme + "://" + u.Host
+}
+
+// postAlert POSTs a JSON body and fails unless the status is 2xx
+func postAlert(rawURL string, header http.Header, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, rawURL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("invalid alert request to %s: %v", alertTarget(rawURL), err)
+	}
+	req.Header = header
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := alertHTTP.Do(req)
+	if err != nil {
+		// The URL may carry a token; report only the cause
+		if urlErr, ok := err.(*url.Error); ok {
+			err = urlErr.Err
+		}
+		return fmt.Errorf("request to %s failed: %w", alertTarget(rawURL), err)
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return &HTTPStatusError{URL: alertTarget(rawURL), StatusCode: resp.StatusCode, Body: bodySnippet(data)}
+	}
+	return nil
+}
+
+// webhookSink POSTs the finding's JSON record, signed with the secret
+type webhookSink struct {
+	url    string
+	secret string
+}
+
+func (w *webhookSink) Send(finding Finding) error {
+	body, err := json.Marshal(findingRecord{SchemaVersion: FindingsSchemaVersion, Finding: finding})
+	if err != nil {
+		return err
+	}
+	header := make(http.Header)
+	if w.secret != "" {
+		header.Set(alertSignatureHeader, signAlert(w.secret, body))
+	}
+	return postAlert(w.url, header, body)
+}
+
+// signAlert returns the signature header value of a webhook body
+func signAlert(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// slackSink POSTs an incoming-webhook message ({"text": ...}), which Slack
+// and compatible chat services accept
+type slackSink struct {
+	url string
+}
+
+func (s *slackSink) Send(finding Finding) error {
+	text := fmt.Sprintf("*%s %s*\n%s", severityIcon(finding.Severity), alertSubject(finding), strings.Join(alertDetails(finding), "\n"))
+	body, err := json.Marshal(map[string]string{"text": text})
+	if err != nil {
+		return err
+	}
+	return postAlert(s.url, make(http.Header), body)
+}
+
+// emailSink sends a plain-text email through an SMTP server, using STARTTLS
+// when the server offers it
+type emailSink struct {
+	config   AlertSinkConfig
+	password string
+}
+
+func (e *emailSink) Send(finding Finding) error {
+	var msg strings.Builder
+	fmt.Fprintf(&msg, "From: %s\r\n", e.config.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(e.config.To, ", "))
+	fmt.Fprintf(&msg, "Subject: [cpimp] %s\r\n", alertSubject(finding))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n")
+	for _, line := range alertDetails(finding) {
+		msg.WriteString(line + "\r\n")
+	}
+
+	var auth smtp.Auth
+	if e.config.Username != "" {
+		auth = smtp.PlainAuth("", e.config.Username, e.password, e.config.SMTPHost)
+	}
+	addr := net.JoinHostPort(e.config.SMTPHost, strconv.Itoa(e.config.SMTPPort))
+	if err := smtp.SendMail(addr, auth, e.config.From, e.config.To, []byte(msg.String())); err != nil {
+		return fmt.Errorf("sending mail through %s failed: %w", addr, err)
+	}
+	return nil
+}
+
+// severityIcon marks an alert by severity
+func severityIcon(severity string) string {
+	if severity == SeverityHigh {
+		return "🚨"
+	}
+	return "🔔"
+}
+
+// alertSubject is the one-line summary of a finding
+func alertSubject(finding Finding) string {
+	network := finding.Network
+	if config, ok := Networks[network]; ok && config.Name != "" {
+		network = config.Name
+	}
+	return fmt.Sprintf("%s %s finding on %s: proxy %s", finding.Severity, finding.Rule, network, finding.Proxy)
+}
+
+// alertDetails lists the transaction, implementations and links of a
+// finding, one per line
+func alertDetails(finding Finding) []string {
+	lines := []string{
+		"Transaction: " + finding.TxHash,
+		fmt.Sprintf("Block: %d", finding.BlockNumber),
+		"From: " + finding.From,
+	}
+	if len(finding.Implementations) > 0 {
+		lines = append(lines, "Implementations: "+strings.Join(finding.ImplementationList(), " > "))
+	}
+	if len(finding.ImplementationChain) > 0 {
+		lines = append(lines, "Current implementation chain: "+strings.Join(finding.ImplementationChain, " -> "))
+	}
+	if events := finding.EventList(); events != "" {
+		lines = append(lines, "Events: "+events)
+	}
+	return append(lines, "Explorer: "+finding.ExplorerLink, "Scan: "+finding.ScanID)
+}
+
+// notifierSink is a sink with its name and severity threshold
+type notifierSink struct {
+	name        string
+	minSeverity string
+	sink        AlertSink
+}
+
+// deadLetter is an alert a sink refused, as written to the dead-letter file
+type deadLetter struct {
+	Sink     string        `json:"sink"`
+	Error    string        `json:"error"`
+	FailedAt time.Time     `json:"failed_at"`
+	Finding  findingRecord `json:"finding"`
+}
+
+// Notifier sends new findings to the alert sinks from a background
+// goroutine, so slow or failing sinks don't hold up the scan. Each sink gets
+// a transaction's first finding for a proxy at or above its severity
+// threshold; deliveries are recorded in the sent log. A delivery is retried
+// with backoff, then written to the dead-letter file; alerts to that sink go
+// straight to the dead-letter file for alertSinkCooldown afterwards. When the
+// queue is full, new alerts go straight to the dead-letter file too.
+type Notifier struct {
+	sinks      []notifierSink
+	deadLetter string
+
+	mu        sync.Mutex // guards the fields below, the sent log and the dead-letter file
+	sentLog   *os.File
+	sent      map[string]bool
+	downUntil map[string]time.Time
+
+	// Alerts already in the dead-letter file, left to replay
+	pending map[string]bool
+
+	MaxAttempts int
+	RetryDelay  time.Duration
+
+	queue chan Finding
+	done  chan struct{}
+
+	delivered    int
+	deadLettered int
+}
+
+// newNotifier creates the sinks of config and loads its sent log. Returns
+// nil when no sink is configured.
+func newNotifier(config AlertConfig) (*Notifier, error) {
+	if len(config.Sinks) == 0 {
+		return nil, nil
+	}
+	n := &Notifier{
+		deadLetter:  config.DeadLetter,
+		downUntil:   make(map[string]time.Time),
+		MaxAttempts: alertMaxAttempts,
+		RetryDelay:  alertRetryDelay,
+	}
+	for _, sink := range config.Sinks {
+		n.sinks = append(n.sinks, notifierSink{name: sink.Name, minSeverity: sink.MinSeverity, sink: newAlertSink(sink)})
+	}
+
+	sent, err := loadSentAlerts(config.SentLog)
+	if err != nil {
+		return nil, err
+	}
+	n.sent = sent
+	pending, err := loadDeadLetters(config.DeadLetter)
+	if err != nil {
+		return nil, err
+	}
+	n.pending = pending
+	n.sentLog, err = os.OpenFile(config.SentLog, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open alert log: %v", err)
+	}
+	return n, nil
+}
+
+// openNotifier starts a notifier for config; nil when no sink is configured
+func openNotifier(config AlertConfig) (*Notifier, error) {
+	n, err := newNotifier(config)
+	if n == nil || err != nil {
+		return nil, err
+	}
+	n.queue = make(chan Finding, alertQueueSize)
+	n.done = make(chan struct{})
+	go func() {
+		defer close(n.done)
+		for finding := range n.queue {
+			n.deliver(finding)
+		}
+	}()
+	return n, nil
+}
+
+// loadSentAlerts returns the keys recorded in the sent log
+func loadSentAlerts(path string) (map[string]bool, error) {
+	sent := make(map[string]bool)
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return sent, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			sent[line] = true
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read alert log %s: %v", path, err)
+	}
+	return sent, nil
+}
+
+// loadDeadLetters returns the keys of the alerts in the dead-letter file
+func loadDeadLetters(path string) (map[string]bool, error) {
+	pending := make(map[string]bool)
+	letters, err := readDeadLetters(path)
+	for _, letter := range letters {
+		pending[alertKey(letter.Sink, letter.Finding.Finding)] = true
+	}
+	return pending, err
+}
+
+// readDeadLetters parses the dead-letter file; a missing file has none
+func readDeadLetters(path string) ([]deadLetter, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var letters []deadLetter
+	for i, line := range bytes.Split(data, []byte("\n")) {
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		var letter deadLetter
+		if err := json.Unmarshal(line, &letter); err != nil {
+			return nil, fmt.Errorf("invalid dead letter in %s (line %d): %v", path, i+1, err)
+		}
+		letters = append(letters, letter)
+	}
+	return letters, nil
+}
+
+// alertKey identifies the alerts of a transaction for a proxy at a sink
+func alertKey(sink string, finding Finding) string {
+	return sink + "\t" + strings.ToLower(finding.TxHash) + "\t" + strings.ToLower(finding.Proxy)
+}
+
+// Notify queues a new finding for the sinks without blocking; a nil notifier
+// ignores it
+func (n *Notifier) Notify(finding Finding) {
+	if n == nil {
+		return
+	}
+	select {
+	case n.queue <- finding:
+	default:
+		// The sinks are falling behind; don't hold up the scan waiting for them
+		logger.Warn("Alert queue is full, saving the alert to the dead-letter file", "tx", finding.TxHash, "address", finding.Proxy)
+		for _, sink := range n.sinks {
+			if n.claim(sink, finding) {
+				n.saveDeadLetter(sink.name, finding, fmt.Errorf("alert queue full"))
+			}
+		}
+	}
+}
+
+// Close waits for the queued alerts to be delivered (or dead-lettered)
+func (n *Notifier) Close() {
+	if n == nil {
+		return
+	}
+	if n.queue != nil {
+		close(n.queue)
+		<-n.done
+	}
+	n.sentLog.Close()
+	if n.delivered > 0 || n.deadLettered > 0 {
+		failed := ""
+		if n.deadLettered > 0 {
+			failed = fmt.Sprintf(", %d failed and saved to %s (resend with: cpimp alerts replay)", n.deadLettered, n.deadLetter)
+		}
+		progressf("📣 Alerts: %d sent%s\n", n.delivered, failed)
+	}
+}
+
+// deliver sends a finding to every sink whose threshold it meets and that
+// hasn't been alerted of its transaction yet
+func (n *Notifier) deliver(finding Finding) {
+	for _, sink := range n.sinks {
+		if !n.claim(sink, finding) {
+			continue
+		}
+		if n.isDown(sink.name) {
+			n.saveDeadLetter(sink.name, finding, fmt.Errorf("sink %s is down", sink.name))
+			continue
+		}
+		if err := n.send(sink, finding); err != nil {
+			logger.Error("Alert failed, saved to the dead-letter file",
+				"sink", sink.name, "tx", finding.TxHash, "attempts", n.MaxAttempts, "err", err)
+			n.saveDeadLetter(sink.name, finding, err)
+			n.mu.Lock()
+			n.downUntil[sink.name] = time.Now().Add(alertSinkCooldown)
+			n.mu.Unlock()
+			continue
+		}
+		n.recordSent(alertKey(sink.name, finding))
+		metricAlerts.Inc(sink.name, "delivered")
+	}
+}
+
+// claim reports whether a sink should be alerted of a finding: it meets the
+// sink's threshold and the sink hasn't had the alert yet. A claimed alert is
+// marked pending so it is handled once; a dead-lettered one is left to replay.
+func (n *Notifier) claim(sink notifierSink, finding Finding) bool {
+	if sink.minSeverity != "" && severityRank(finding.Severity) < severityRank(sink.minSeverity) {
+		return false
+	}
+	key := alertKey(sink.name, finding)
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.sent[key] || n.pending[key] {
+		return false
+	}
+	n.pending[key] = true
+	return true
+}
+
+// isDown reports whether a sink is cooling down after a failed delivery
+func (n *Notifier) isDown(sink string) bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return time.Now().Before(n.downUntil[sink])
+}
+
+// send delivers a finding to one sink, retrying with exponential backoff
+func (n *Notifier) send(sink notifierSink, finding Finding) error {
+	var err error
+	for attempt := 1; attempt <= n.MaxAttempts; attempt++ {
+		if err = sink.sink.Send(finding); err == nil {
+			logger.Debug("Alert sent", "sink", sink.name, "tx", finding.TxHash)
+			return nil
+		}
+		if attempt < n.MaxAttempts {
+			delay := n.RetryDelay << (attempt - 1)
+			logger.Warn("Alert failed, retrying", "sink", sink.name, "tx", finding.TxHash,
+				"attempt", attempt, "max_attempts", n.MaxAttempts, "delay", delay, "err", err)
+			time.Sleep(delay)
+		}
+	}
+	return err
+}
+
+// recordSent marks an alert delivered and appends it to the sent log
+func (n *Notifier) recordSent(key string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.sent[key] = true
+	n.delivered++
+	if _, err := fmt.Fprintln(n.sentLog, key); err != nil {
+		logger.Error("Failed to record sent alert", "file", n.sentLog.Name(), "err", err)
+	}
+}
+
+// saveDeadLetter appends an alert a sink refused to the dead-letter file
+func (n *Notifier) saveDeadLetter(sink string, finding Finding, sendErr error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.deadLettered++
+	metricAlerts.Inc(sink, "dead_lettered")
+	line, err := json.Marshal(deadLetter{
+		Sink:     sink,
+		Error:    sendErr.Error(),
+		FailedAt: time.Now().UTC(),
+		Finding:  findingRecord{SchemaVersion: FindingsSchemaVersion, Finding: finding},
+	})
+	if err == nil {
+		var file *os.File
+		file, err = os.OpenFile(n.deadLetter, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err == nil {
+			_, err = file.Write(append(line, '\n'))
+			file.Close()
+		}
+	}
+	if err != nil {
+		logger.Error("Failed to save alert to the dead-letter file", "sink", sink, "tx", finding.TxHash, "file", n.deadLetter, "err", err)
+	}
+}
+
+// replayDeadLetters resends the dead-lettered alerts of config to their
+// sinks. Alerts that fail again, or whose sink is no longer configured, stay
+// in the file. Returns the number sent and kept.
+func replayDeadLetters(config AlertConfig) (int, int, error) {
+	letters, err := readDeadLetters(config.DeadLetter)
+	if err != nil || len(letters) == 0 {
+		return 0, 0, err
+	}
+	n, err := newNotifier(config)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer n.sentLog.Close()
+	sinks := make(map[string]notifierSink)
+	for _, sink := range n.sinks {
+		sinks[sink.name] = sink
+	}
+
+	var kept bytes.Buffer
+	sent, remaining := 0, 0
+	for _, letter := range letters {
+		sink, ok := sinks[letter.Sink]
+		key := alertKey(letter.Sink, letter.Finding.Finding)
+		switch {
+		case n.sent[key]:
+			// Delivered since, e.g. by an earlier replay
+			continue
+		case !ok:
+			logger.Warn("Alert sink is no longer configured; keeping its alert", "sink", letter.Sink, "tx", letter.Finding.TxHash)
+		default:
+			err := n.send(sink, letter.Finding.Finding)
+			if err == nil {
+				n.recordSent(key)
+				sent++
+				continue
+			}
+			logger.Error("Alert failed again", "sink", letter.Sink, "tx", letter.Finding.TxHash, "err", err)
+			letter.Error = err.Error()
+			letter.FailedAt = time.Now().UTC()
+		}
+		line, err := json.Marshal(letter)
+		if err != nil {
+			return sent, 0, err
+		}
+		kept.Write(append(line, '\n'))
+		remaining++
+	}
+
+	if remaining == 0 {
+		return sent, 0, os.Remove(config.DeadLetter)
+	}
+	return sent, remaining, writeFileAtomic(config.DeadLetter, kept.Bytes(), "")
+}
+
+// testFinding is the sample finding sent by "cpimp alerts test"
+func testFinding(config ScannerConfig) Finding {
+	network := Networks[config.Network]
+	txHash := "0x" + strings.Repeat("0", 64)
+	now := time.Now().UTC()
+	return Finding{
+		ScanID:              "test",
+		Network:             config.Network,
+		ChainID:             network.ChainID,
+		Rule:                "test",
+		Severity:            SeverityHigh,
+		Proxy:               "0x" + strings.Repeat("0", 40),
+		TxHash:              txHash,
+		Timestamp:           &now,
+		From:                "0x" + strings.Repeat("0", 40),
+		ExplorerLink:        network.TxURL(txHash),
+		Events:              []DecodedEvent{},
+		Implementations:     []ImplementationChange{},
+		ImplementationChain: []string{},
+	}
+}
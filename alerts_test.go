@@ -0,0 +1,420 @@
+package main
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// alertRecorder is an HTTP stand-in for webhook and Slack sinks, counting
+// the requests per path and failing them while fail is set
+type alertRecorder struct {
+	mu       sync.Mutex
+	requests map[string]int
+	bodies   [][]byte
+	headers  []http.Header
+	fail     bool
+}
+
+func newAlertServer(t *testing.T) (*alertRecorder, *httptest.Server) {
+	rec := &alertRecorder{requests: make(map[string]int)}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		rec.mu.Lock()
+		defer rec.mu.Unlock()
+		rec.requests[r.URL.Path]++
+		rec.bodies = append(rec.bodies, body)
+		rec.headers = append(rec.headers, r.Header.Clone())
+		if rec.fail {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return rec, srv
+}
+
+func (rec *alertRecorder) count(path string) int {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	return rec.requests[path]
+}
+
+func (rec *alertRecorder) setFail(fail bool) {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	rec.fail = fail
+}
+
+// testAlertConfig keeps the sent log and dead-letter file in a temp dir
+func testAlertConfig(t *testing.T, sinks ...AlertSinkConfig) AlertConfig {
+	dir := t.TempDir()
+	return AlertConfig{
+		Sinks:      sinks,
+		DeadLetter: filepath.Join(dir, "dead_letter.ndjson"),
+		SentLog:    filepath.Join(dir, "sent.log"),
+	}
+}
+
+// testNotifier creates a notifier that delivers synchronously through
+// deliver, retrying without delay
+func testNotifier(t *testing.T, config AlertConfig) *Notifier {
+	n, err := newNotifier(config)
+	if err != nil {
+		t.Fatalf("newNotifier: %v", err)
+	}
+	n.MaxAttempts = 2
+	n.RetryDelay = time.Millisecond
+	t.Cleanup(func() { n.sentLog.Close() })
+	return n
+}
+
+func testAlertFinding(txHash, severity string) Finding {
+	return Finding{
+		ScanID:       "scan1",
+		Network:      "ethereum",
+		ChainID:      1,
+		Rule:         "upgraded-multiple",
+		Severity:     severity,
+		Proxy:        "0x1111111111111111111111111111111111111111",
+		TxHash:       txHash,
+		BlockNumber:  100,
+		From:         "0x2222222222222222222222222222222222222222",
+		ExplorerLink: "https://explorer.test/tx/" + txHash,
+		Implementations: []ImplementationChange{
+			{LogIndex: 1, Implementation: "0x3333333333333333333333333333333333333333"},
+		},
+		ImplementationChain: []string{},
+	}
+}
+
+func TestWebhookSinkSignsBody(t *testing.T) {
+	rec, srv := newAlertServer(t)
+	sink := newAlertSink(AlertSinkConfig{Type: SinkWebhook, URL: srv.URL + "/hook", Secret: "s3cret"})
+	finding := testAlertFinding("0xaa", SeverityHigh)
+	if err := sink.Send(finding); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+
+	body := rec.bodies[0]
+	mac := hmac.New(sha256.New, []byte("s3cret"))
+	mac.Write(body)
+	want := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	if got := rec.headers[0].Get(alertSignatureHeader); got != want {
+		t.Errorf("signature = %q, want %q", got, want)
+	}
+	var record findingRecord
+	if err := json.Unmarshal(body, &record); err != nil {
+		t.Fatalf("webhook body is not a finding record: %v", err)
+	}
+	if record.SchemaVersion != FindingsSchemaVersion || record.TxHash != finding.TxHash || record.Proxy != finding.Proxy {
+		t.Errorf("webhook record = %+v", record)
+	}
+}
+
+func TestWebhookSinkWithoutSecretIsUnsigned(t *testing.T) {
+	rec, srv := newAlertServer(t)
+	sink := newAlertSink(AlertSinkConfig{Type: SinkWebhook, URL: srv.URL})
+	if err := sink.Send(testAlertFinding("0xaa", SeverityHigh)); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if got := rec.headers[0].Get(alertSignatureHeader); got != "" {
+		t.Errorf("unexpected signature %q", got)
+	}
+}
+
+func TestWebhookSinkFailsOnErrorStatus(t *testing.T) {
+	rec, srv := newAlertServer(t)
+	rec.setFail(true)
+	sink := newAlertSink(AlertSinkConfig{Type: SinkWebhook, URL: srv.URL})
+	err := sink.Send(testAlertFinding("0xaa", SeverityHigh))
+	if statusErr, ok := err.(*HTTPStatusError); !ok || statusErr.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("Send error = %v, want a 503 HTTPStatusError", err)
+	}
+}
+
+func TestSlackSinkPayload(t *testing.T) {
+	rec, srv := newAlertServer(t)
+	sink := newAlertSink(AlertSinkConfig{Type: SinkSlack, URL: srv.URL})
+	finding := testAlertFinding("0xaa", SeverityHigh)
+	if err := sink.Send(finding); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+
+	var payload map[string]string
+	if err := json.Unmarshal(rec.bodies[0], &payload); err != nil {
+		t.Fatalf("Slack body: %v", err)
+	}
+	if len(payload) != 1 {
+		t.Errorf("Slack payload has fields besides text: %v", payload)
+	}
+	for _, want := range []string{"🚨", alertSubject(finding), finding.TxHash, finding.ExplorerLink, finding.Implementations[0].Implementation} {
+		if !strings.Contains(payload["text"], want) {
+			t.Errorf("Slack text missing %q:\n%s", want, payload["text"])
+		}
+	}
+}
+
+// smtpStandIn is a minimal SMTP server accepting every message
+type smtpStandIn struct {
+	addr     *net.TCPAddr
+	messages chan string
+}
+
+func newSMTPStandIn(t *testing.T) *smtpStandIn {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	s := &smtpStandIn{addr: listener.Addr().(*net.TCPAddr), messages: make(chan string, 10)}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *smtpStandIn) serve(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	reply := func(line string) { io.WriteString(conn, line+"\r\n") }
+	reply("220 localhost ESMTP")
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		switch command := strings.ToUpper(strings.Fields(line + " ")[0]); command {
+		case "EHLO", "HELO":
+			reply("250 localhost")
+		case "DATA":
+			reply("354 End data with <CR><LF>.<CR><LF>")
+			var msg strings.Builder
+			for {
+				line, err := reader.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if line == ".\r\n" {
+					break
+				}
+				msg.WriteString(line)
+			}
+			s.messages <- msg.String()
+			reply("250 OK")
+		case "QUIT":
+			reply("221 Bye")
+			return
+		default:
+			reply("250 OK")
+		}
+	}
+}
+
+func TestEmailSinkSendsThroughSMTP(t *testing.T) {
+	server := newSMTPStandIn(t)
+	sink := newAlertSink(AlertSinkConfig{
+		Type:     SinkEmail,
+		SMTPHost: server.addr.IP.String(),
+		SMTPPort: server.addr.Port,
+		From:     "scanner@example.com",
+		To:       []string{"oncall@example.com", "security@example.com"},
+	})
+	finding := testAlertFinding("0xaa", SeverityMedium)
+	if err := sink.Send(finding); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+
+	msg := <-server.messages
+	for _, want := range []string{
+		"From: scanner@example.com",
+		"To: oncall@example.com, security@example.com",
+		"Subject: [cpimp] " + alertSubject(finding),
+		"Transaction: " + finding.TxHash,
+		finding.ExplorerLink,
+	} {
+		if !strings.Contains(msg, want) {
+			t.Errorf("email missing %q:\n%s", want, msg)
+		}
+	}
+}
+
+func TestEmailSinkReportsUnreachableServer(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	addr := listener.Addr().(*net.TCPAddr)
+	listener.Close()
+
+	sink := newAlertSink(AlertSinkConfig{Type: SinkEmail, SMTPHost: "127.0.0.1", SMTPPort: addr.Port,
+		From: "scanner@example.com", To: []string{"oncall@example.com"}})
+	err = sink.Send(testAlertFinding("0xaa", SeverityHigh))
+	if err == nil || !strings.Contains(err.Error(), "127.0.0.1:"+strconv.Itoa(addr.Port)) {
+		t.Fatalf("Send error = %v, want one naming the server", err)
+	}
+}
+
+func TestNotifierSeverityThreshold(t *testing.T) {
+	rec, srv := newAlertServer(t)
+	n := testNotifier(t, testAlertConfig(t,
+		AlertSinkConfig{Name: "all", Type: SinkWebhook, URL: srv.URL + "/all"},
+		AlertSinkConfig{Name: "high", Type: SinkWebhook, URL: srv.URL + "/high", MinSeverity: SeverityHigh},
+	))
+
+	n.deliver(testAlertFinding("0x01", SeverityLow))
+	n.deliver(testAlertFinding("0x02", SeverityMedium))
+	n.deliver(testAlertFinding("0x03", SeverityHigh))
+
+	if got := rec.count("/all"); got != 3 {
+		t.Errorf("sink without threshold got %d alerts, want 3", got)
+	}
+	if got := rec.count("/high"); got != 1 {
+		t.Errorf("high-severity sink got %d alerts, want 1", got)
+	}
+}
+
+func TestNotifierDedupAcrossRestarts(t *testing.T) {
+	rec, srv := newAlertServer(t)
+	config := testAlertConfig(t, AlertSinkConfig{Name: "hook", Type: SinkWebhook, URL: srv.URL})
+
+	n := testNotifier(t, config)
+	finding := testAlertFinding("0xAA", SeverityHigh)
+	n.deliver(finding)
+	// Another rule matching the same transaction doesn't alert again
+	other := finding
+	other.Rule = "admin-changed"
+	n.deliver(other)
+	n.sentLog.Close()
+	if got := rec.count("/"); got != 1 {
+		t.Fatalf("got %d alerts before the restart, want 1", got)
+	}
+
+	n = testNotifier(t, config)
+	restarted := finding
+	restarted.TxHash = "0xaa"
+	n.deliver(restarted)
+	if got := rec.count("/"); got != 1 {
+		t.Errorf("finding alerted before the restart was sent again (%d alerts)", got)
+	}
+	n.deliver(testAlertFinding("0xbb", SeverityHigh))
+	if got := rec.count("/"); got != 2 {
+		t.Errorf("new finding after the restart: got %d alerts, want 2", got)
+	}
+}
+
+func TestNotifierDeadLettersAndReplays(t *testing.T) {
+	rec, srv := newAlertServer(t)
+	config := testAlertConfig(t, AlertSinkConfig{Name: "hook", Type: SinkWebhook, URL: srv.URL})
+	rec.setFail(true)
+
+	n := testNotifier(t, config)
+	finding := testAlertFinding("0xaa", SeverityHigh)
+	n.deliver(finding)
+	if got := rec.count("/"); got != n.MaxAttempts {
+		t.Errorf("got %d attempts, want %d", got, n.MaxAttempts)
+	}
+	// The sink is cooling down, so the next alert skips it
+	n.deliver(testAlertFinding("0xbb", SeverityHigh))
+	if got := rec.count("/"); got != n.MaxAttempts {
+		t.Errorf("alert was sent to a sink that is down (%d requests)", got)
+	}
+	n.sentLog.Close()
+
+	letters, err := readDeadLetters(config.DeadLetter)
+	if err != nil {
+		t.Fatalf("readDeadLetters: %v", err)
+	}
+	if len(letters) != 2 || letters[0].Sink != "hook" || letters[0].Finding.TxHash != "0xaa" || letters[0].Error == "" {
+		t.Fatalf("dead letters = %+v", letters)
+	}
+
+	// A restarted notifier leaves dead-lettered alerts to the replay
+	rec.setFail(false)
+	n = testNotifier(t, config)
+	n.deliver(finding)
+	if got := rec.count("/"); got != n.MaxAttempts {
+		t.Errorf("dead-lettered alert was resent outside the replay (%d requests)", got)
+	}
+	n.sentLog.Close()
+
+	sent, kept, err := replayDeadLetters(config)
+	if err != nil || sent != 2 || kept != 0 {
+		t.Fatalf("replayDeadLetters = %d sent, %d kept, %v; want 2, 0, nil", sent, kept, err)
+	}
+	if _, err := os.Stat(config.DeadLetter); !os.IsNotExist(err) {
+		t.Errorf("dead-letter file still exists after a full replay: %v", err)
+	}
+	sentAlerts, err := loadSentAlerts(config.SentLog)
+	if err != nil || !sentAlerts[alertKey("hook", finding)] {
+		t.Errorf("replayed alert missing from the sent log: %v, %v", sentAlerts, err)
+	}
+}
+
+func TestReplayKeepsAlertsThatFailAgain(t *testing.T) {
+	rec, srv := newAlertServer(t)
+	config := testAlertConfig(t,
+		AlertSinkConfig{Name: "hook", Type: SinkWebhook, URL: srv.URL},
+		AlertSinkConfig{Name: "gone", Type: SinkWebhook, URL: srv.URL + "/gone"},
+	)
+	rec.setFail(true)
+	n := testNotifier(t, config)
+	n.deliver(testAlertFinding("0xaa", SeverityHigh))
+	n.sentLog.Close()
+
+	// The "gone" sink was removed from the config since
+	rec.setFail(false)
+	config.Sinks = config.Sinks[:1]
+	sent, kept, err := replayDeadLetters(config)
+	if err != nil || sent != 1 || kept != 1 {
+		t.Fatalf("replayDeadLetters = %d sent, %d kept, %v; want 1, 1, nil", sent, kept, err)
+	}
+	letters, err := readDeadLetters(config.DeadLetter)
+	if err != nil || len(letters) != 1 || letters[0].Sink != "gone" {
+		t.Fatalf("kept dead letters = %+v, %v", letters, err)
+	}
+}
+
+func TestNotifyDoesNotBlockWhenQueueFull(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { <-release }))
+	defer srv.Close()
+	config := testAlertConfig(t, AlertSinkConfig{Name: "slow", Type: SinkWebhook, URL: srv.URL})
+	n, err := openNotifier(config)
+	if err != nil {
+		t.Fatalf("openNotifier: %v", err)
+	}
+
+	total := alertQueueSize + 10
+	start := time.Now()
+	for i := 0; i < total; i++ {
+		n.Notify(testAlertFinding("0x"+strconv.Itoa(i), SeverityHigh))
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Notify blocked for %v with a stalled sink", elapsed)
+	}
+	close(release)
+	n.Close()
+
+	if n.deadLettered == 0 || n.delivered+n.deadLettered != total {
+		t.Errorf("%d delivered, %d dead-lettered; want some dead-lettered and %d in all", n.delivered, n.deadLettered, total)
+	}
+}
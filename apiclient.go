@@ -0,0 +1,281 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Defaults of the shared API client
+const (
+	apiRequestTimeout = 60 * time.Second
+	apiMaxAttempts    = 4
+	apiBaseBackoff    = 1 * time.Second
+	apiMaxBackoff     = 30 * time.Second
+
+	// A Retry-After longer than this is not waited out; the request fails
+	// with a RateLimitError instead
+	apiMaxRetryAfter = 2 * time.Minute
+)
+
+// APIClient performs the HTTP requests of every backend. Each request has a
+// timeout and waits for the token bucket of its endpoint host; rate limiting
+// (429), server errors (5xx) and transport errors such as timeouts are
+// retried with jittered exponential backoff, honoring the server's
+// Retry-After.
+type APIClient struct {
+	HTTP        *http.Client
+	MaxAttempts int
+	BaseBackoff time.Duration
+	MaxBackoff  time.Duration
+
+	mu        sync.Mutex
+	rateLimit time.Duration // interval between requests to a host
+	buckets   map[string]*tokenBucket
+}
+
+// NewAPIClient returns a client allowing one request per rateLimit to each
+// endpoint host (0 = unlimited). A scan creates one and shares it between
+// its backend and all of its workers.
+func NewAPIClient(rateLimit time.Duration) *APIClient {
+	return &APIClient{
+		HTTP:        &http.Client{Timeout: apiRequestTimeout},
+		MaxAttempts: apiMaxAttempts,
+		BaseBackoff: apiBaseBackoff,
+		MaxBackoff:  apiMaxBackoff,
+		rateLimit:   rateLimit,
+	}
+}
+
+// RateLimitError is returned when the API is still rate limiting the client
+// after all retries (or asks it to wait longer than apiMaxRetryAfter)
+type RateLimitError struct {
+	URL        string
+	RetryAfter time.Duration // 0 when the server didn't say
+}
+
+func (e *RateLimitError) Error() string {
+	if e.RetryAfter > 0 {
+		return fmt.Sprintf("rate limited by %s (retry after %v)", e.URL, e.RetryAfter)
+	}
+	return fmt.Sprintf("rate limited by %s", e.URL)
+}
+
+// NotFoundError is returned for a 404 response
+type NotFoundError struct {
+	URL string
+}
+
+func (e *NotFoundError) Error() string {
+	return fmt.Sprintf("%s not found (status 404)", e.URL)
+}
+
+// HTTPStatusError is returned for any other unsuccessful status
+type HTTPStatusError struct {
+	URL        string
+	StatusCode int
+	Body       string // start of the response body
+}
+
+func (e *HTTPStatusError) Error() string {
+	return fmt.Sprintf("%s returned status %d: %s", e.URL, e.StatusCode, e.Body)
+}
+
+// MalformedResponseError is returned when a response body isn't the JSON the
+// caller expected, e.g. an HTML error page served with status 200
+type MalformedResponseError struct {
+	URL  string
+	Body string // start of the response body
+	Err  error
+}
+
+func (e *MalformedResponseError) Error() string {
+	return fmt.Sprintf("malformed response from %s: %v (body: %s)", e.URL, e.Err, e.Body)
+}
+
+func (e *MalformedResponseError) Unwrap() error { return e.Err }
+
+// Get fetches rawURL and returns the response body
+func (c *APIClient) Get(rawURL string) ([]byte, error) {
+	return c.do(http.MethodGet, rawURL, "", nil, nil)
+}
+
+// GetChecked fetches rawURL like Get, for APIs that report rate limiting
+// in a successful response: a body for which rateLimited returns true is
+// retried and throttled like a 429
+func (c *APIClient) GetChecked(rawURL string, rateLimited func(body []byte) bool) ([]byte, error) {
+	return c.do(http.MethodGet, rawURL, "", nil, rateLimited)
+}
+
+// Post sends body to rawURL and returns the response body
+func (c *APIClient) Post(rawURL, contentType string, body []byte) ([]byte, error) {
+	return c.do(http.MethodPost, rawURL, contentType, body, nil)
+}
+
+// GetJSON fetches rawURL and decodes its JSON body into result
+func (c *APIClient) GetJSON(rawURL string, result interface{}) error {
+	body, err := c.Get(rawURL)
+	if err != nil {
+		return err
+	}
+	return decodeResponse(rawURL, body, result)
+}
+
+func (c *APIClient) do(method, rawURL, contentType string, body []byte, rateLimited func([]byte) bool) ([]byte, error) {
+	target := redactURL(rawURL)
+	bucket := c.bucket(rawURL)
+	for attempt := 1; ; attempt++ {
+		if bucket != nil {
+			bucket.Wait()
+		}
+
+		var reader io.Reader
+		if body != nil {
+			reader = bytes.NewReader(body)
+		}
+		req, err := http.NewRequest(method, rawURL, reader)
+		if err != nil {
+			return nil, fmt.Errorf("invalid request to %s: %v", target, err)
+		}
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+
+		var retryAfter time.Duration
+		sent := time.Now()
+		resp, err := c.HTTP.Do(req)
+		status := 0
+		if resp != nil {
+			status = resp.StatusCode
+		}
+		observeAPIRequest(rawURL, status, time.Since(sent))
+		if err != nil {
+			// Report the cause without the URL, which may carry an API key
+			if urlErr, ok := err.(*url.Error); ok {
+				err = urlErr.Err
+			}
+			err = fmt.Errorf("request to %s failed: %w", target, err)
+		} else {
+			data, readErr := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			retryAfter = parseRetryAfter(resp.Header.Get("Retry-After"))
+
+			switch {
+			case readErr != nil:
+				err = fmt.Errorf("failed to read response from %s: %w", target, readErr)
+			case resp.StatusCode >= 200 && resp.StatusCode < 300 && rateLimited != nil && rateLimited(data):
+				err = &RateLimitError{URL: target}
+				c.Throttle(rawURL)
+			case resp.StatusCode >= 200 && resp.StatusCode < 300:
+				if bucket != nil {
+					bucket.Recover()
+				}
+				return data, nil
+			case resp.StatusCode == http.StatusTooManyRequests:
+				err = &RateLimitError{URL: target, RetryAfter: retryAfter}
+				c.Throttle(rawURL)
+			case resp.StatusCode == http.StatusNotFound:
+				return nil, &NotFoundError{URL: target}
+			case resp.StatusCode >= 500:
+				err = &HTTPStatusError{URL: target, StatusCode: resp.StatusCode, Body: bodySnippet(data)}
+			default:
+				// Other client errors won't succeed on retry
+				return nil, &HTTPStatusError{URL: target, StatusCode: resp.StatusCode, Body: bodySnippet(data)}
+			}
+		}
+
+		if attempt >= c.MaxAttempts {
+			return nil, err
+		}
+		delay := c.backoff(attempt)
+		if retryAfter > apiMaxRetryAfter {
+			return nil, err
+		}
+		if retryAfter > delay {
+			delay = retryAfter
+		}
+		metricAPIRetries.Inc(endpointLabel(rawURL), retryReason(err))
+		httpLogger.Debug("Request failed, retrying", "endpoint", endpointLabel(rawURL), "attempt", attempt,
+			"max_attempts", c.MaxAttempts, "delay", delay.Truncate(time.Millisecond), "err", err)
+		time.Sleep(delay)
+	}
+}
+
+// backoff returns the delay before retry number attempt: exponential from
+// BaseBackoff, capped at MaxBackoff, with the upper half jittered so that
+// concurrent workers don't retry in lockstep
+func (c *APIClient) backoff(attempt int) time.Duration {
+	delay := c.BaseBackoff << (attempt - 1)
+	if delay <= 0 || delay > c.MaxBackoff {
+		delay = c.MaxBackoff
+	}
+	half := delay / 2
+	if half <= 0 {
+		return delay
+	}
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
+
+// parseRetryAfter reads a Retry-After header given in seconds or as an HTTP
+// date (0 when absent or invalid)
+func parseRetryAfter(value string) time.Duration {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if when, err := http.ParseTime(value); err == nil {
+		if wait := time.Until(when); wait > 0 {
+			return wait
+		}
+	}
+	return 0
+}
+
+// decodeResponse decodes a JSON response body, reporting bodies that aren't
+// valid JSON for result as a MalformedResponseError
+func decodeResponse(rawURL string, body []byte, result interface{}) error {
+	if err := json.Unmarshal(body, result); err != nil {
+		return &MalformedResponseError{URL: redactURL(rawURL), Body: bodySnippet(body), Err: err}
+	}
+	return nil
+}
+
+// redactURL removes credentials (an apikey parameter or user info) from a
+// URL used in errors and logs
+func redactURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "API"
+	}
+	u.User = nil
+	query := u.Query()
+	if query.Has("apikey") {
+		query.Del("apikey")
+		u.RawQuery = query.Encode()
+	}
+	return u.String()
+}
+
+// bodySnippet returns the start of a response body on a single line
+func bodySnippet(body []byte) string {
+	const maxLen = 120
+	s := strings.Join(strings.Fields(string(body)), " ")
+	if len(s) > maxLen {
+		s = s[:maxLen] + "..."
+	}
+	if s == "" {
+		s = "<empty>"
+	}
+	return s
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// writeFileAtomic replaces path with data so that readers (and a restart
+// after a crash) see either the old or the new content, never a partial
+// write: the data goes to a temporary file in the same directory, is synced
+// to disk and then renamed over path. When backupPath is set the previous
+// content of path is kept there.
+func writeFileAtomic(path string, data []byte, backupPath string) error {
+	dir := filepath.Dir(path)
+	tmp, err := os.CreateTemp(dir, filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	// Removing the temporary file fails harmlessly once it has been renamed
+	defer os.Remove(tmp.Name())
+
+	if err := tmp.Chmod(0644); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if backupPath != "" {
+		// A crash between the two renames leaves only the backup, which the
+		// loader falls back to
+		if err := os.Rename(path, backupPath); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	return syncDir(dir)
+}
+
+// syncDir flushes a directory entry change (a rename) to disk
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	// Not every platform supports syncing a directory; the rename has still
+	// happened
+	d.Sync()
+	return nil
+}
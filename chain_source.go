@@ -0,0 +1,304 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// Supported chain data backends
+const (
+	BackendBlockscout = "blockscout"
+	BackendRPC        = "rpc"
+	BackendEtherscan  = "etherscan"
+)
+
+// ErrLogLimitExceeded is returned by FetchLogs when the backend refused a
+// block range because it would return too many results; the caller should
+// retry with a smaller range
+var ErrLogLimitExceeded = errors.New("log query exceeds backend result limit")
+
+// ContractCreation rejects addresses that can't be scanned with these errors
+// (possibly wrapped); any other error means the lookup itself failed
+var (
+	ErrNotContract  = errors.New("address is not a smart contract")
+	ErrNotProxy     = errors.New("not a proxy contract (no implementations found)")
+	ErrNoCreationTx = errors.New("no creation transaction found")
+)
+
+// ChainSource is the chain data backend used by a scan
+type ChainSource interface {
+	// Name identifies the backend in logs
+	Name() string
+
+	// LatestBlockNumber returns the current head block
+	LatestBlockNumber() (uint64, error)
+
+	// FetchLogs returns the logs whose topic0 is any of topics emitted in
+	// [fromBlock, toBlock] by the given addresses (all addresses if empty)
+	FetchLogs(topics []string, fromBlock, toBlock uint64, addresses []string) ([]LogEntry, error)
+
+	// LogsCap is the maximum number of logs a single FetchLogs call returns
+	// before silently truncating (0 = the backend never truncates)
+	LogsCap() int
+
+	// TransactionFrom returns the sender of a transaction
+	TransactionFrom(txHash string) (string, error)
+
+	// ContractCreation returns the creation block and transaction of a proxy
+	// contract (0 and "" when the backend cannot tell), or ErrNotContract,
+	// ErrNotProxy or ErrNoCreationTx for an address that can't be scanned
+	ContractCreation(address string) (uint64, string, error)
+
+	// StorageAt returns the 32-byte storage word at slot of a contract at
+	// the latest block
+	StorageAt(address, slot string) (string, error)
+
+	// Call returns the result of an eth_call of a contract with the given
+	// calldata at the latest block
+	Call(to, data string) (string, error)
+
+	// BlockTimestamp returns the time a block was mined
+	BlockTimestamp(block uint64) (time.Time, error)
+
+	// BlockHeader returns the hash and parent hash of a block on the
+	// current canonical chain
+	BlockHeader(block uint64) (BlockHeader, error)
+}
+
+// BlockHeader identifies a block and its parent, for reorg detection
+type BlockHeader struct {
+	Number     uint64
+	Hash       string
+	ParentHash string
+}
+
+// rpcBlockHeader is the part of an eth_getBlockByNumber result the scanner uses
+type rpcBlockHeader struct {
+	Timestamp  string `json:"timestamp"`
+	Hash       string `json:"hash"`
+	ParentHash string `json:"parentHash"`
+}
+
+// blockHeader converts an eth_getBlockByNumber result
+func (h *rpcBlockHeader) blockHeader(block uint64) (BlockHeader, error) {
+	if h == nil {
+		return BlockHeader{}, fmt.Errorf("block %d not found", block)
+	}
+	if h.Hash == "" {
+		return BlockHeader{}, fmt.Errorf("block %d has no hash", block)
+	}
+	return BlockHeader{Number: block, Hash: strings.ToLower(h.Hash), ParentHash: strings.ToLower(h.ParentHash)}, nil
+}
+
+// blockTime converts a block header's hex timestamp
+func (h *rpcBlockHeader) blockTime(block uint64) (time.Time, error) {
+	if h == nil {
+		return time.Time{}, fmt.Errorf("block %d not found", block)
+	}
+	seconds, err := parseHexUint64(h.Timestamp)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid timestamp for block %d: %v", block, err)
+	}
+	return time.Unix(int64(seconds), 0).UTC(), nil
+}
+
+// ContractCreationResult is the creation block and transaction of a contract
+type ContractCreationResult struct {
+	Block  uint64
+	TxHash string
+
+	// Why the contract isn't scanned, e.g. ErrNotProxy (nil = it is)
+	Err error
+}
+
+// BatchCreationSource is implemented by backends that can look up the
+// creation of several contracts in one call
+type BatchCreationSource interface {
+	ChainSource
+
+	// CreationBatchSize is the maximum number of addresses per call
+	CreationBatchSize() int
+
+	// ContractCreations returns the creations keyed by lower-case address;
+	// addresses missing from the result are not contracts
+	ContractCreations(addresses []string) (map[string]ContractCreationResult, error)
+}
+
+// backendName returns the effective backend of a scan
+func backendName(config ScannerConfig, network NetworkConfig) string {
+	if config.Backend != "" {
+		return config.Backend
+	}
+	if network.Backend != "" {
+		return network.Backend
+	}
+	return BackendBlockscout
+}
+
+// rpcURL returns the effective JSON-RPC endpoint of a scan
+func rpcURL(config ScannerConfig, network NetworkConfig) string {
+	if config.RPCURL != "" {
+		return config.RPCURL
+	}
+	return network.RPCURL
+}
+
+// apiKey returns the effective API key of a scan: the scan config's, the
+// network's, or the one in the environment variable the network names
+func apiKey(config ScannerConfig, network NetworkConfig) string {
+	if config.APIKey != "" {
+		return config.APIKey
+	}
+	if network.APIKey != "" {
+		return network.APIKey
+	}
+	if network.APIKeyEnv != "" {
+		return os.Getenv(network.APIKeyEnv)
+	}
+	return ""
+}
+
+// newChainSource creates the backend selected by the scan config or network,
+// sending its requests through client
+func newChainSource(config ScannerConfig, network NetworkConfig, client *APIClient) (ChainSource, error) {
+	switch backend := backendName(config, network); backend {
+	case BackendBlockscout:
+		if network.BlockscoutURL == "" {
+			return nil, fmt.Errorf("network %s has no blockscout_url", network.Name)
+		}
+		return &BlockscoutSource{BaseURL: network.BlockscoutURL, Client: client}, nil
+	case BackendRPC:
+		url := rpcURL(config, network)
+		if url == "" {
+			return nil, fmt.Errorf("backend %q requires an RPC URL for network %s", backend, network.Name)
+		}
+		return &RPCSource{URL: url, Client: client}, nil
+	case BackendEtherscan:
+		if network.EtherscanURL == "" {
+			return nil, fmt.Errorf("backend %q requires an etherscan_url for network %s", backend, network.Name)
+		}
+		return &EtherscanSource{BaseURL: network.EtherscanURL, APIKey: apiKey(config, network), Client: client}, nil
+	default:
+		return nil, fmt.Errorf("unknown backend %q", backend)
+	}
+}
+
+// isKnownBackend reports whether name is a supported backend
+func isKnownBackend(name string) bool {
+	switch name {
+	case BackendBlockscout, BackendRPC, BackendEtherscan:
+		return true
+	}
+	return false
+}
+
+// BlockscoutSource reads chain data from a Blockscout instance
+type BlockscoutSource struct {
+	BaseURL string
+	Client  *APIClient
+}
+
+func (b *BlockscoutSource) Name() string { return BackendBlockscout }
+
+func (b *BlockscoutSource) LatestBlockNumber() (uint64, error) {
+	return getLatestBlockNumber(b.Client, b.BaseURL)
+}
+
+// FetchLogs filters on one topic0 per call; see fetchAnyTopic
+func (b *BlockscoutSource) FetchLogs(topics []string, fromBlock, toBlock uint64, addresses []string) ([]LogEntry, error) {
+	return fetchAnyTopic(topics, addresses, BlockscoutLogsCap, func(topic string) ([]LogEntry, error) {
+		return fetchLogs(b.Client, b.BaseURL, topic, fromBlock, toBlock, addresses)
+	})
+}
+
+// fetchAnyTopic matches any of several topic0 values on a backend whose
+// getLogs filters on a single one, through fetch (topic "" = no topic
+// filter). For target addresses it makes one unfiltered call and keeps the
+// matching logs; if that call comes back full (logsCap logs, or
+// ErrLogLimitExceeded), as it does for busy contracts, each topic is fetched
+// on its own instead. Without addresses every topic is fetched on its own.
+func fetchAnyTopic(topics, addresses []string, logsCap int, fetch func(topic string) ([]LogEntry, error)) ([]LogEntry, error) {
+	if len(topics) > 1 && len(addresses) > 0 {
+		logs, err := fetch("")
+		full := errors.Is(err, ErrLogLimitExceeded) || (err == nil && logsCap > 0 && len(logs) >= logsCap)
+		if err != nil && !full {
+			return nil, err
+		}
+		if !full {
+			return filterLogsByTopic(logs, topics), nil
+		}
+	}
+
+	var logs []LogEntry
+	for _, topic := range topics {
+		topicLogs, err := fetch(topic)
+		if err != nil {
+			return nil, err
+		}
+		logs = append(logs, topicLogs...)
+	}
+	return logs, nil
+}
+
+// filterLogsByTopic returns the logs whose topic0 is one of topics
+func filterLogsByTopic(logs []LogEntry, topics []string) []LogEntry {
+	wanted := make(map[string]bool)
+	for _, topic := range topics {
+		wanted[strings.ToLower(topic)] = true
+	}
+	matching := []LogEntry{}
+	for _, log := range logs {
+		if len(log.Topics) > 0 && wanted[strings.ToLower(log.Topics[0])] {
+			matching = append(matching, log)
+		}
+	}
+	return matching
+}
+
+func (b *BlockscoutSource) LogsCap() int { return BlockscoutLogsCap }
+
+func (b *BlockscoutSource) TransactionFrom(txHash string) (string, error) {
+	return getTransactionFrom(b.Client, b.BaseURL, txHash)
+}
+
+func (b *BlockscoutSource) ContractCreation(address string) (uint64, string, error) {
+	return getContractCreationBlock(b.Client, b.BaseURL, address)
+}
+
+// StorageAt uses Blockscout's JSON-RPC compatible endpoint
+func (b *BlockscoutSource) StorageAt(address, slot string) (string, error) {
+	var word string
+	if err := rpcCall(b.Client, b.BaseURL+"/api/eth-rpc", "eth_getStorageAt", []interface{}{address, slot, "latest"}, &word); err != nil {
+		return "", err
+	}
+	return word, nil
+}
+
+// Call uses Blockscout's JSON-RPC compatible endpoint
+func (b *BlockscoutSource) Call(to, data string) (string, error) {
+	var result string
+	call := map[string]string{"to": to, "data": data}
+	if err := rpcCall(b.Client, b.BaseURL+"/api/eth-rpc", "eth_call", []interface{}{call, "latest"}, &result); err != nil {
+		return "", err
+	}
+	return result, nil
+}
+
+func (b *BlockscoutSource) BlockTimestamp(block uint64) (time.Time, error) {
+	var header *rpcBlockHeader
+	if err := rpcCall(b.Client, b.BaseURL+"/api/eth-rpc", "eth_getBlockByNumber", []interface{}{toHexQuantity(block), false}, &header); err != nil {
+		return time.Time{}, err
+	}
+	return header.blockTime(block)
+}
+
+func (b *BlockscoutSource) BlockHeader(block uint64) (BlockHeader, error) {
+	var header *rpcBlockHeader
+	if err := rpcCall(b.Client, b.BaseURL+"/api/eth-rpc", "eth_getBlockByNumber", []interface{}{toHexQuantity(block), false}, &header); err != nil {
+		return BlockHeader{}, err
+	}
+	return header.blockHeader(block)
+}
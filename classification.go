@@ -0,0 +1,128 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// Address classifications recorded in ContractInfo.Status. Only valid
+// addresses are scanned; api_error addresses are looked up again when the
+// scan is resumed.
+const (
+	StatusValid        = "valid"
+	StatusNotContract  = "not_contract"
+	StatusNotProxy     = "not_proxy"
+	StatusNoCreationTx = "no_creation_tx"
+	StatusAPIError     = "api_error"
+)
+
+// addressStatuses lists the classifications in report order
+var addressStatuses = []string{StatusValid, StatusNotContract, StatusNotProxy, StatusNoCreationTx, StatusAPIError}
+
+// isSkippedStatus reports whether status is a classification of addresses
+// that aren't scanned
+func isSkippedStatus(status string) bool {
+	for _, skipped := range addressStatuses[1:] {
+		if status == skipped {
+			return true
+		}
+	}
+	return false
+}
+
+// classifyCreationError maps a ContractCreation error to the address's
+// classification
+func classifyCreationError(err error) string {
+	switch {
+	case errors.Is(err, ErrNotContract):
+		return StatusNotContract
+	case errors.Is(err, ErrNotProxy):
+		return StatusNotProxy
+	case errors.Is(err, ErrNoCreationTx):
+		return StatusNoCreationTx
+	default:
+		return StatusAPIError
+	}
+}
+
+// apiErrorKind describes a failed lookup for the progress output
+func apiErrorKind(err error) string {
+	var (
+		rateLimited *RateLimitError
+		notFound    *NotFoundError
+		statusErr   *HTTPStatusError
+		malformed   *MalformedResponseError
+	)
+	switch {
+	case errors.As(err, &rateLimited):
+		return "Rate limited"
+	case errors.As(err, &notFound):
+		return "Not found"
+	case errors.As(err, &statusErr), errors.As(err, &malformed):
+		return "API error"
+	default:
+		return "Error"
+	}
+}
+
+// Scannable reports whether the address is scanned for events. Progress
+// saved before classifications were recorded only holds valid addresses.
+func (info ContractInfo) Scannable() bool {
+	return info.Status == "" || info.Status == StatusValid
+}
+
+// classification returns the address's status, treating an unset one as valid
+func (info ContractInfo) classification() string {
+	if info.Status == "" {
+		return StatusValid
+	}
+	return info.Status
+}
+
+// addressesWithStatus returns the addresses of a scan with the given
+// classification, sorted
+func addressesWithStatus(progress AddressProgress, status string) []string {
+	var addresses []string
+	for address, info := range progress.Addresses {
+		if info.classification() == status {
+			addresses = append(addresses, address)
+		}
+	}
+	sort.Strings(addresses)
+	return addresses
+}
+
+// countScannable returns the number of addresses scanned for events
+func countScannable(progress AddressProgress) int {
+	count := 0
+	for _, info := range progress.Addresses {
+		if info.Scannable() {
+			count++
+		}
+	}
+	return count
+}
+
+// printClassificationBreakdown reports to w how a scan's addresses were
+// classified, listing the ones whose lookup failed
+func printClassificationBreakdown(w io.Writer, progress AddressProgress, indent string) {
+	if len(progress.Addresses) == 0 {
+		return
+	}
+	counts := make(map[string]int)
+	for _, info := range progress.Addresses {
+		counts[info.classification()]++
+	}
+
+	fmt.Fprintf(w, "%sAddress classification:\n", indent)
+	for _, status := range addressStatuses {
+		if counts[status] > 0 {
+			fmt.Fprintf(w, "%s  %s: %d\n", indent, status, counts[status])
+		}
+	}
+	for _, address := range addressesWithStatus(progress, StatusAPIError) {
+		fmt.Fprintf(w, "%s    %s: %s\n", indent, address, progress.Addresses[address].StatusError)
+	}
+}
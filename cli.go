@@ -0,0 +1,601 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const usageText = `Usage: cpimp <command> [options]
+
+Commands:
+  scan                      Run (or resume) a scan
+  scan retry-gaps <scan-id> Retry block ranges that failed during a scan
+  scan reclassify <scan-id> Look up a scan's skipped addresses again
+  watch                     Run a scan, then keep scanning new blocks as they are mined
+  scans list [-store DB]    List active scans
+  scans show <scan-id>      Show details for a scan (partial IDs accepted)
+  scans rm <scan-id>        Delete a scan's progress file and stored rows (partial IDs accepted)
+  scans gc [-older-than D]  Remove progress and completed scan files (or unfinished scans with -store) older than D (default 72h)
+  config validate           Print the effective configuration and its scan ID
+  networks list [-check]    List known networks, optionally verifying chain IDs
+  rules list                List the detection rules
+  events list               List the built-in event signatures and their topics
+  alerts test               Send a sample finding to every configured alert sink
+  alerts replay             Resend the alerts saved in the dead-letter file
+  help                      Show this help
+
+Running cpimp without a command is equivalent to "cpimp scan".
+Run "cpimp scan -h" to see all scan options.
+
+Configuration is merged from, in increasing order of precedence: built-in
+defaults, the file given by -config (YAML or JSON), CPIMP_* environment
+variables (e.g. CPIMP_NETWORK, CPIMP_BLOCK_RANGE) and explicit flags.
+
+With -store (or CPIMP_STORE) scan progress, raw logs and findings are kept in
+an SQLite database; scans list/show, scan retry-gaps and scan reclassify read
+from it too.
+
+Networks beyond the built-in ones are loaded from the registry file given by
+-networks, CPIMP_NETWORKS_FILE or ./networks.yaml.
+`
+
+func printUsage() {
+	fmt.Fprint(os.Stderr, usageText)
+}
+
+// runCLI dispatches command-line arguments to a subcommand and returns the
+// process exit code
+func runCLI(args []string) int {
+	// Scan flags can override these
+	if err := configureLogging(os.Getenv(EnvLogLevel), os.Getenv(EnvLogFormat)); err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid logging configuration: %v\n", err)
+		return 2
+	}
+
+	if len(args) == 0 {
+		return runScanCommand(nil)
+	}
+
+	switch args[0] {
+	case "scan":
+		return runScanCommand(args[1:])
+	case "scans":
+		return runScansCommand(args[1:])
+	case "watch":
+		return runWatchCommand(args[1:])
+	case "config":
+		return runConfigCommand(args[1:])
+	case "networks":
+		return runNetworksCommand(args[1:])
+	case "rules":
+		return runRulesCommand(args[1:])
+	case "events":
+		return runEventsCommand(args[1:])
+	case "alerts":
+		return runAlertsCommand(args[1:])
+	case "help", "-h", "-help", "--help":
+		printUsage()
+		return 0
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown command: %s\n\n", args[0])
+		printUsage()
+		return 2
+	}
+}
+
+// scanFlags holds the raw values of the scan command flags
+type scanFlags struct {
+	configFile    string
+	networksFile  string
+	network       string
+	eventTopic    string
+	blockRange    uint64
+	rateLimit     time.Duration
+	concurrency   int
+	startBlock    uint64
+	endBlock      uint64
+	outputFile    string
+	outputFormat  string
+	store         string
+	addresses     string
+	addressFile   string
+	backend       string
+	rpcURL        string
+	apiKey        string
+	rules         string
+	pollInterval  time.Duration
+	confirmations int
+	metricsAddr   string
+	logLevel      string
+	logFormat     string
+}
+
+// bindScanFlags registers a flag for every ScannerConfig field on fs
+func bindScanFlags(fs *flag.FlagSet) *scanFlags {
+	defaults := DefaultConfig()
+	f := &scanFlags{}
+	fs.StringVar(&f.configFile, "config", "", "YAML or JSON scan config file")
+	fs.StringVar(&f.networksFile, "networks", defaultNetworksFile(), "YAML or JSON networks registry file (env "+EnvNetworksFile+")")
+	fs.StringVar(&f.network, "network", defaults.Network, "network to scan (key from the Networks map)")
+	fs.StringVar(&f.eventTopic, "event-topic", defaults.EventTopic, "event to scan for: a signature such as Upgraded(address), a built-in event name or a topic0 hash")
+	fs.Uint64Var(&f.blockRange, "block-range", defaults.BlockRange, "number of blocks per API call (0 = network default)")
+	fs.DurationVar(&f.rateLimit, "rate-limit", defaults.RateLimit, "minimum delay between API calls to each host, shared by all workers (0 = network default)")
+	fs.IntVar(&f.concurrency, "concurrency", defaults.Concurrency, "number of addresses scanned concurrently (0 = network default)")
+	fs.Uint64Var(&f.startBlock, "start-block", defaults.StartBlock, "first block to scan (0 = contract creation block)")
+	fs.Uint64Var(&f.endBlock, "end-block", defaults.EndBlock, "last block to scan (0 = latest)")
+	fs.StringVar(&f.outputFile, "output", "", "output file (default <network>_address_list_scan.<format> or <network>_upgraded_transactions.<format>)")
+	fs.StringVar(&f.outputFormat, "format", "", "output format: csv, json or ndjson (default csv)")
+	fs.StringVar(&f.store, "store", "", "SQLite database for progress, raw logs and findings (env "+EnvStore+")")
+	fs.StringVar(&f.addresses, "addresses", "", "comma-separated list of target addresses")
+	fs.StringVar(&f.addressFile, "address-file", defaults.AddressFile, "file with one target address per line (empty = scan all addresses)")
+	fs.StringVar(&f.backend, "backend", "", "chain data backend: blockscout, rpc or etherscan (default: the network's backend)")
+	fs.StringVar(&f.rpcURL, "rpc-url", "", "JSON-RPC endpoint overriding the network's rpc_url")
+	fs.StringVar(&f.apiKey, "api-key", "", "API key overriding the network's (prefer env "+EnvAPIKey+")")
+	fs.StringVar(&f.rules, "rules", "", "comma-separated detection rules to evaluate (default: all; see 'cpimp rules list')")
+	fs.DurationVar(&f.pollInterval, "poll-interval", defaults.PollInterval, "delay between polls for new blocks in watch mode (0 = 15s)")
+	fs.IntVar(&f.confirmations, "confirmations", defaults.Confirmations, "blocks behind the chain head treated as final (-1 = network default)")
+	fs.StringVar(&f.metricsAddr, "metrics-addr", "", "address serving Prometheus metrics at /metrics, e.g. :9464 (env "+EnvMetricsAddr+")")
+	fs.StringVar(&f.logLevel, "log-level", "", "log level, optionally with per-component levels, e.g. info,http=debug (env "+EnvLogLevel+")")
+	fs.StringVar(&f.logFormat, "log-format", "", "log format: text or json (env "+EnvLogFormat+")")
+	return f
+}
+
+// overrides returns the flags explicitly set on the command line as a
+// config overlay
+func (f *scanFlags) overrides(fs *flag.FlagSet) ScanConfigFile {
+	var file ScanConfigFile
+	fs.Visit(func(fl *flag.Flag) {
+		switch fl.Name {
+		case "network":
+			file.Network = &f.network
+		case "event-topic":
+			file.EventTopic = &f.eventTopic
+		case "rules":
+			file.Rules = splitAddressList(f.rules)
+		case "block-range":
+			file.BlockRange = &f.blockRange
+		case "rate-limit":
+			rateLimit := f.rateLimit.String()
+			file.RateLimit = &rateLimit
+		case "concurrency":
+			file.Concurrency = &f.concurrency
+		case "start-block":
+			file.StartBlock = &f.startBlock
+		case "end-block":
+			file.EndBlock = &f.endBlock
+		case "output":
+			file.OutputFile = &f.outputFile
+		case "format":
+			file.OutputFormat = &f.outputFormat
+		case "store":
+			file.Store = &f.store
+		case "addresses":
+			file.TargetAddresses = splitAddressList(f.addresses)
+		case "address-file":
+			file.AddressFile = &f.addressFile
+		case "backend":
+			file.Backend = &f.backend
+		case "rpc-url":
+			file.RPCURL = &f.rpcURL
+		case "api-key":
+			file.APIKey = &f.apiKey
+		case "confirmations":
+			file.Confirmations = &f.confirmations
+		case "metrics-addr":
+			file.MetricsAddr = &f.metricsAddr
+		case "poll-interval":
+			pollInterval := f.pollInterval.String()
+			file.PollInterval = &pollInterval
+		}
+	})
+	return file
+}
+
+// effectiveConfig merges, in increasing order of precedence, the built-in
+// defaults, the -config file, CPIMP_* environment variables and explicitly
+// set flags, then validates the result
+func (f *scanFlags) effectiveConfig(fs *flag.FlagSet) (ScannerConfig, error) {
+	config := DefaultConfig()
+
+	if f.networksFile != "" {
+		if err := LoadNetworkRegistry(f.networksFile); err != nil {
+			return config, err
+		}
+	}
+
+	if f.configFile != "" {
+		file, err := LoadScanConfigFile(f.configFile)
+		if err != nil {
+			return config, err
+		}
+		if err := file.Apply(&config); err != nil {
+			return config, fmt.Errorf("invalid config file %s: %v", f.configFile, err)
+		}
+	}
+
+	if err := ApplyEnvOverrides(&config); err != nil {
+		return config, err
+	}
+
+	if err := f.overrides(fs).Apply(&config); err != nil {
+		return config, fmt.Errorf("invalid flags: %v", err)
+	}
+
+	config.Finalize()
+	return config, config.Validate()
+}
+
+// applyLogFlags reconfigures logging when -log-level or -log-format is set;
+// the other keeps its environment value
+func (f *scanFlags) applyLogFlags(fs *flag.FlagSet) error {
+	level, format := os.Getenv(EnvLogLevel), os.Getenv(EnvLogFormat)
+	set := false
+	fs.Visit(func(fl *flag.Flag) {
+		switch fl.Name {
+		case "log-level":
+			level, set = f.logLevel, true
+		case "log-format":
+			format, set = f.logFormat, true
+		}
+	})
+	if !set {
+		return nil
+	}
+	return configureLogging(level, format)
+}
+
+// parseScanFlags parses the scan flags in args and returns the effective config
+func parseScanFlags(name string, args []string) (ScannerConfig, int) {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags := bindScanFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return ScannerConfig{}, 2
+	}
+	if fs.NArg() > 0 {
+		fmt.Fprintf(os.Stderr, "Unexpected arguments: %s\n", strings.Join(fs.Args(), " "))
+		return ScannerConfig{}, 2
+	}
+	if err := flags.applyLogFlags(fs); err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid logging configuration: %v\n", err)
+		return ScannerConfig{}, 2
+	}
+
+	config, err := flags.effectiveConfig(fs)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid configuration: %v\n", err)
+		return config, 1
+	}
+	return config, 0
+}
+
+func runScanCommand(args []string) int {
+	if len(args) > 0 && args[0] == "retry-gaps" {
+		return runRetryGapsCommand(args[1:])
+	}
+	if len(args) > 0 && args[0] == "reclassify" {
+		return runReclassifyCommand(args[1:])
+	}
+
+	config, code := parseScanFlags("scan", args)
+	if code != 0 {
+		return code
+	}
+
+	runScan(config)
+	return 0
+}
+
+// runWatchCommand runs a scan in watch mode; it takes the scan options and
+// returns once interrupted
+func runWatchCommand(args []string) int {
+	config, code := parseScanFlags("watch", args)
+	if code != 0 {
+		return code
+	}
+	config.Watch = true
+	if err := config.Validate(); err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid configuration: %v\n", err)
+		return 1
+	}
+
+	runScan(config)
+	return 0
+}
+
+func runRetryGapsCommand(args []string) int {
+	fs := flag.NewFlagSet("scan retry-gaps", flag.ContinueOnError)
+	networksFile := fs.String("networks", defaultNetworksFile(), "YAML or JSON networks registry file (env "+EnvNetworksFile+")")
+	outputFile := fs.String("output", "", "output file in the scan's format (default: the scan's original output file)")
+	rateLimit := fs.Duration("rate-limit", 0, "delay between API calls (0 = network default)")
+	rpcURL := fs.String("rpc-url", "", "JSON-RPC endpoint overriding the network's rpc_url")
+	apiKey := fs.String("api-key", os.Getenv(EnvAPIKey), "API key overriding the network's (env "+EnvAPIKey+")")
+	storePath := fs.String("store", os.Getenv(EnvStore), "SQLite store holding the scan's progress (env "+EnvStore+")")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	if *networksFile != "" {
+		if err := LoadNetworkRegistry(*networksFile); err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid networks registry: %v\n", err)
+			return 1
+		}
+	}
+
+	store, ok := openStoreArg(*storePath)
+	if !ok {
+		return 1
+	}
+	if store != nil {
+		defer store.Close()
+	}
+
+	scanID, ok := scanIDArg("scan retry-gaps", fs.Args(), store)
+	if !ok {
+		return 1
+	}
+
+	remaining, err := RetryScanGaps(scanID, *outputFile, *rateLimit, *rpcURL, *apiKey, store)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Retry failed: %v\n", err)
+		return 1
+	}
+	if remaining > 0 {
+		progressf("%d coverage gap(s) still open.\n", remaining)
+		return 1
+	}
+	return 0
+}
+
+func runReclassifyCommand(args []string) int {
+	fs := flag.NewFlagSet("scan reclassify", flag.ContinueOnError)
+	networksFile := fs.String("networks", defaultNetworksFile(), "YAML or JSON networks registry file (env "+EnvNetworksFile+")")
+	statusList := fs.String("status", "", "comma-separated classifications to look up again (default: every skipped address)")
+	rateLimit := fs.Duration("rate-limit", 0, "delay between API calls (0 = network default)")
+	rpcURL := fs.String("rpc-url", "", "JSON-RPC endpoint overriding the network's rpc_url")
+	apiKey := fs.String("api-key", os.Getenv(EnvAPIKey), "API key overriding the network's (env "+EnvAPIKey+")")
+	storePath := fs.String("store", os.Getenv(EnvStore), "SQLite store holding the scan's progress (env "+EnvStore+")")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	var statuses []string
+	for _, status := range splitAddressList(*statusList) {
+		if !isSkippedStatus(status) {
+			fmt.Fprintf(os.Stderr, "Invalid -status %q (expected %s)\n", status, strings.Join(addressStatuses[1:], ", "))
+			return 2
+		}
+		statuses = append(statuses, status)
+	}
+
+	if *networksFile != "" {
+		if err := LoadNetworkRegistry(*networksFile); err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid networks registry: %v\n", err)
+			return 1
+		}
+	}
+
+	store, ok := openStoreArg(*storePath)
+	if !ok {
+		return 1
+	}
+	if store != nil {
+		defer store.Close()
+	}
+
+	scanID, ok := scanIDArg("scan reclassify", fs.Args(), store)
+	if !ok {
+		return 1
+	}
+
+	if _, err := ReclassifyScan(scanID, statuses, *rateLimit, *rpcURL, *apiKey, store); err != nil {
+		fmt.Fprintf(os.Stderr, "Reclassify failed: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+func runConfigCommand(args []string) int {
+	if len(args) == 0 || args[0] != "validate" {
+		fmt.Fprintln(os.Stderr, "Usage: cpimp config validate [scan options]")
+		return 2
+	}
+
+	config, code := parseScanFlags("config validate", args[1:])
+	if code != 0 {
+		return code
+	}
+
+	out, err := yaml.Marshal(ScanConfigFileFrom(config))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to encode configuration: %v\n", err)
+		return 1
+	}
+
+	fmt.Println("# Effective configuration")
+	fmt.Print(string(out))
+	fmt.Printf("\nTarget addresses: %d\n", len(config.TargetAddresses))
+	fmt.Printf("Configuration is valid.\n")
+	fmt.Printf("Scan ID: %s\n", generateScanID(config))
+	return 0
+}
+
+func runNetworksCommand(args []string) int {
+	if len(args) == 0 || args[0] != "list" {
+		fmt.Fprintln(os.Stderr, "Usage: cpimp networks list [-networks file] [-check]")
+		return 2
+	}
+
+	fs := flag.NewFlagSet("networks list", flag.ContinueOnError)
+	networksFile := fs.String("networks", defaultNetworksFile(), "YAML or JSON networks registry file (env "+EnvNetworksFile+")")
+	check := fs.Bool("check", false, "verify each network's chain ID against its endpoint")
+	if err := fs.Parse(args[1:]); err != nil {
+		return 2
+	}
+
+	if *networksFile != "" {
+		if err := LoadNetworkRegistry(*networksFile); err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid networks registry: %v\n", err)
+			return 1
+		}
+	}
+
+	if !ListNetworks(*check) {
+		return 1
+	}
+	return 0
+}
+
+func runRulesCommand(args []string) int {
+	if len(args) != 1 || args[0] != "list" {
+		fmt.Fprintln(os.Stderr, "Usage: cpimp rules list")
+		return 2
+	}
+	ListRules()
+	return 0
+}
+
+func runEventsCommand(args []string) int {
+	if len(args) != 1 || args[0] != "list" {
+		fmt.Fprintln(os.Stderr, "Usage: cpimp events list")
+		return 2
+	}
+	ListEvents()
+	return 0
+}
+
+func runScansCommand(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "Missing scans subcommand (list, show, rm, gc)")
+		return 2
+	}
+
+	switch args[0] {
+	case "list", "ls", "show", "rm", "delete":
+		fs := flag.NewFlagSet("scans "+args[0], flag.ContinueOnError)
+		storePath := fs.String("store", os.Getenv(EnvStore), "SQLite store to read scans from instead of progress files (env "+EnvStore+")")
+		if err := fs.Parse(args[1:]); err != nil {
+			return 2
+		}
+		store, ok := openStoreArg(*storePath)
+		if !ok {
+			return 1
+		}
+		if store != nil {
+			defer store.Close()
+		}
+
+		if args[0] == "list" || args[0] == "ls" {
+			ListActiveScans(store)
+			return 0
+		}
+		scanID, ok := scanIDArg("scans "+args[0], fs.Args(), store)
+		if !ok {
+			return 1
+		}
+		if args[0] == "show" {
+			ShowScanDetails(scanID, store)
+		} else {
+			DeleteScan(scanID, store)
+		}
+		return 0
+	case "gc", "cleanup":
+		fs := flag.NewFlagSet("scans gc", flag.ContinueOnError)
+		olderThan := fs.Duration("older-than", 72*time.Hour, "remove progress files (or unfinished stored scans) last updated before this duration ago")
+		storePath := fs.String("store", os.Getenv(EnvStore), "SQLite store to remove scans from instead of progress files (env "+EnvStore+")")
+		if err := fs.Parse(args[1:]); err != nil {
+			return 2
+		}
+		store, ok := openStoreArg(*storePath)
+		if !ok {
+			return 1
+		}
+		if store != nil {
+			defer store.Close()
+		}
+		CleanupOldScans(*olderThan, store)
+		return 0
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown scans subcommand: %s\n", args[0])
+		return 2
+	}
+}
+
+// openStoreArg opens the store named by a -store flag (nil when empty)
+func openStoreArg(path string) (*Store, bool) {
+	if path == "" {
+		return nil, true
+	}
+	store, err := OpenStore(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return nil, false
+	}
+	return store, true
+}
+
+// scanIDArg resolves the single (possibly partial) scan ID argument of a
+// scans subcommand, among the store's scans when one is given
+func scanIDArg(command string, args []string, store *Store) (string, bool) {
+	if len(args) != 1 {
+		fmt.Fprintf(os.Stderr, "Usage: cpimp %s <scan-id>\n", command)
+		return "", false
+	}
+
+	scanID := findScanByPartialID(args[0], store)
+	if scanID == "" {
+		fmt.Printf("Scan ID %s not found.\n", args[0])
+		return "", false
+	}
+	return scanID, true
+}
+
+// runAlertsCommand tests the alert sinks of a scan configuration or resends
+// its dead letters
+func runAlertsCommand(args []string) int {
+	if len(args) == 0 || (args[0] != "test" && args[0] != "replay") {
+		fmt.Fprintln(os.Stderr, "Usage: cpimp alerts test|replay [scan options]")
+		return 2
+	}
+
+	config, code := parseScanFlags("alerts "+args[0], args[1:])
+	if code != 0 {
+		return code
+	}
+	if len(config.Alerts.Sinks) == 0 {
+		fmt.Fprintln(os.Stderr, "No alert sinks configured (alerts.sinks in the -config file)")
+		return 1
+	}
+
+	if args[0] == "replay" {
+		sent, kept, err := replayDeadLetters(config.Alerts)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to replay alerts: %v\n", err)
+			return 1
+		}
+		fmt.Printf("Resent %d alert(s); %d left in %s\n", sent, kept, config.Alerts.DeadLetter)
+		if kept > 0 {
+			return 1
+		}
+		return 0
+	}
+
+	finding := testFinding(config)
+	failed := 0
+	for _, sinkConfig := range config.Alerts.Sinks {
+		if err := newAlertSink(sinkConfig).Send(finding); err != nil {
+			fmt.Printf("❌ %s (%s): %v\n", sinkConfig.Name, sinkConfig.Type, err)
+			failed++
+			continue
+		}
+		fmt.Printf("✅ %s (%s): sent\n", sinkConfig.Name, sinkConfig.Type)
+	}
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
@@ -1,42 +1,128 @@
 package main
 
-import "time"
+import (
+	"fmt"
+	"strings"
+	"time"
+)
 
 // Configuration for different blockchain networks
 type NetworkConfig struct {
 	Name          string
+	ChainID       uint64
 	BlockscoutURL string
 	ExplorerURL   string
+
+	// Transaction link template; {tx} is replaced by the transaction hash
+	// (empty = ExplorerURL + "/tx/{tx}")
+	ExplorerTxURL string
+
+	// Optional JSON-RPC endpoint for the chain
+	RPCURL string
+
+	// Chain data backend: "blockscout" (default), "rpc" or "etherscan"
+	Backend string
+
+	// Etherscan-compatible API endpoint and its key (APIKeyEnv names an
+	// environment variable holding the key, to keep it out of the file)
+	EtherscanURL string
+	APIKey       string
+	APIKeyEnv    string
+
+	// Defaults used when the scan config leaves BlockRange / RateLimit /
+	// Concurrency / Confirmations unset
+	DefaultBlockRange    uint64
+	DefaultRateLimit     time.Duration
+	DefaultConcurrency   int
+	DefaultConfirmations uint64
+}
+
+// TxURL returns the explorer link for a transaction
+func (n NetworkConfig) TxURL(txHash string) string {
+	if n.ExplorerTxURL != "" {
+		return strings.ReplaceAll(n.ExplorerTxURL, "{tx}", txHash)
+	}
+	if n.ExplorerURL == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s/tx/%s", n.ExplorerURL, txHash)
 }
 
+// RateLimit returns the network's default rate limit, or FallbackRateLimit
+// when it doesn't set one
+func (n NetworkConfig) RateLimit() time.Duration {
+	if n.DefaultRateLimit == 0 {
+		return FallbackRateLimit
+	}
+	return n.DefaultRateLimit
+}
+
+// Built-in networks; entries can be added or overridden with a networks
+// registry file (see LoadNetworkRegistry)
 var Networks = map[string]NetworkConfig{
 	"base": {
-		Name:          "Base",
-		BlockscoutURL: "https://base.blockscout.com",
-		ExplorerURL:   "https://base.blockscout.com",
+		Name:                 "Base",
+		ChainID:              8453,
+		BlockscoutURL:        "https://base.blockscout.com",
+		ExplorerURL:          "https://base.blockscout.com",
+		DefaultBlockRange:    10000,
+		DefaultRateLimit:     500 * time.Millisecond,
+		DefaultConfirmations: 5,
 	},
 	"ethereum": {
-		Name:          "Ethereum",
-		BlockscoutURL: "https://eth.blockscout.com",
-		ExplorerURL:   "https://eth.blockscout.com",
+		Name:                 "Ethereum",
+		ChainID:              1,
+		BlockscoutURL:        "https://eth.blockscout.com",
+		ExplorerURL:          "https://eth.blockscout.com",
+		DefaultBlockRange:    5000, // Smaller range for Ethereum
+		DefaultRateLimit:     1000 * time.Millisecond,
+		DefaultConfirmations: 12,
 	},
 	"polygon": {
-		Name:          "Polygon",
-		BlockscoutURL: "https://polygon.blockscout.com",
-		ExplorerURL:   "https://polygon.blockscout.com",
+		Name:                 "Polygon",
+		ChainID:              137,
+		BlockscoutURL:        "https://polygon.blockscout.com",
+		ExplorerURL:          "https://polygon.blockscout.com",
+		DefaultBlockRange:    10000,
+		DefaultRateLimit:     500 * time.Millisecond,
+		DefaultConfirmations: 32,
 	},
 	"optimism": {
-		Name:          "Optimism",
-		BlockscoutURL: "https://optimism.blockscout.com",
-		ExplorerURL:   "https://optimism.blockscout.com",
+		Name:                 "Optimism",
+		ChainID:              10,
+		BlockscoutURL:        "https://optimism.blockscout.com",
+		ExplorerURL:          "https://optimism.blockscout.com",
+		DefaultBlockRange:    10000,
+		DefaultRateLimit:     500 * time.Millisecond,
+		DefaultConfirmations: 5,
 	},
 	"story": {
-		Name:          "Story",
-		BlockscoutURL: "https://www.storyscan.io",
-		ExplorerURL:   "https://www.storyscan.io",
+		Name:                 "Story",
+		ChainID:              1514,
+		BlockscoutURL:        "https://www.storyscan.io",
+		ExplorerURL:          "https://www.storyscan.io",
+		DefaultBlockRange:    50000,
+		DefaultRateLimit:     300 * time.Millisecond,
+		DefaultConfirmations: 5,
 	},
 }
 
+// Fallbacks when neither the scan config nor the network sets a value
+const (
+	FallbackBlockRange  = 10000
+	FallbackRateLimit   = 500 * time.Millisecond
+	FallbackConcurrency = 1
+
+	// Delay between polls for new blocks in watch mode
+	FallbackPollInterval = 15 * time.Second
+)
+
+// UpgradedEventTopic is keccak256("Upgraded(address)")
+var UpgradedEventTopic = registryTopic("Upgraded(address)")
+
+// Default address file used when no addresses are given on the command line
+const DefaultAddressFile = "eco_projects.txt"
+
 // Scanner configuration
 type ScannerConfig struct {
 	// Network to scan (use key from Networks map)
@@ -46,28 +132,88 @@ type ScannerConfig struct {
 	// Default: keccak256("Upgraded(address)")
 	EventTopic string
 
+	// Event declared by EventTopic when it was given as a signature or name
+	// (nil for a topic hash); registered at scan start so its logs decode
+	Event *EventSignature
+
+	// Detection rules to evaluate (see Rules; empty = all rules)
+	Rules []string
+
 	// Block range for each API call (to avoid timeouts)
+	// 0 = the network's default block range
 	BlockRange uint64
 
-	// Rate limiting delay between API calls
+	// Rate limiting delay between API calls, shared by all workers
+	// 0 = the network's default rate limit
 	RateLimit time.Duration
 
+	// Number of addresses scanned concurrently
+	// 0 = the network's default concurrency
+	Concurrency int
+
 	// Starting block (0 for genesis)
 	StartBlock uint64
 
 	// Ending block (0 for latest)
 	EndBlock uint64
 
-	// Output CSV filename
+	// Output filename
 	OutputFile string
 
+	// Output format: "csv" (default), "json" or "ndjson"
+	OutputFormat string
+
+	// SQLite database holding progress, raw logs and findings
+	// (empty = progress file only)
+	Store string
+
 	// Specific addresses to scan (empty = scan all addresses)
 	// When provided, only events from these addresses will be checked
 	TargetAddresses []string
+
+	// File to load TargetAddresses from, one address per line
+	// (ignored when TargetAddresses is set)
+	AddressFile string
+
+	// Chain data backend ("" = the network's backend, see ChainSource)
+	Backend string
+
+	// JSON-RPC endpoint overriding the network's rpc_url
+	RPCURL string
+
+	// API key overriding the network's, for backends that need one
+	APIKey string
+
+	// Keep following the chain head after the scan (set by "cpimp watch")
+	Watch bool
+
+	// Delay between polls for new blocks in watch mode
+	// 0 = FallbackPollInterval
+	PollInterval time.Duration
+
+	// Blocks behind the chain head that are treated as final; scans and
+	// watches stop this far behind the head (-1 = the network's default)
+	Confirmations int
+
+	// Sinks notified of new findings (none = no alerts)
+	Alerts AlertConfig
+
+	// Address serving Prometheus metrics at /metrics, e.g. ":9464"
+	// (empty = no metrics endpoint)
+	MetricsAddr string
 }
 
-// Default configuration - uses Story network
+// Default configuration - uses Story network with addresses from DefaultAddressFile
 func DefaultConfig() ScannerConfig {
-	// return StoryAddressListConfig("eco_projects.txt")
-	return StoryAddressListConfig("eco_projects.txt")
+	return ScannerConfig{
+		Network:     "story",
+		EventTopic:  UpgradedEventTopic,
+		BlockRange:  0, // network default
+		RateLimit:   0, // network default
+		StartBlock:  0,
+		EndBlock:    0, // 0 means latest
+		AddressFile: DefaultAddressFile,
+
+		Confirmations: -1, // network default
+	}
 }
@@ -0,0 +1,472 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ScanConfigFile is the on-disk representation of a ScannerConfig. Every
+// field is optional; unset fields keep the value of the configuration the
+// file is applied on top of.
+type ScanConfigFile struct {
+	Network         *string  `json:"network,omitempty" yaml:"network,omitempty"`
+	EventTopic      *string  `json:"event_topic,omitempty" yaml:"event_topic,omitempty"`
+	Rules           []string `json:"rules,omitempty" yaml:"rules,omitempty"`
+	BlockRange      *uint64  `json:"block_range,omitempty" yaml:"block_range,omitempty"`
+	RateLimit       *string  `json:"rate_limit,omitempty" yaml:"rate_limit,omitempty"`
+	Concurrency     *int     `json:"concurrency,omitempty" yaml:"concurrency,omitempty"`
+	StartBlock      *uint64  `json:"start_block,omitempty" yaml:"start_block,omitempty"`
+	EndBlock        *uint64  `json:"end_block,omitempty" yaml:"end_block,omitempty"`
+	OutputFile      *string  `json:"output_file,omitempty" yaml:"output_file,omitempty"`
+	OutputFormat    *string  `json:"output_format,omitempty" yaml:"output_format,omitempty"`
+	Store           *string  `json:"store,omitempty" yaml:"store,omitempty"`
+	TargetAddresses []string `json:"target_addresses,omitempty" yaml:"target_addresses,omitempty"`
+	AddressFile     *string  `json:"address_file,omitempty" yaml:"address_file,omitempty"`
+	Backend         *string  `json:"backend,omitempty" yaml:"backend,omitempty"`
+	RPCURL          *string  `json:"rpc_url,omitempty" yaml:"rpc_url,omitempty"`
+	APIKey          *string  `json:"api_key,omitempty" yaml:"api_key,omitempty"`
+	PollInterval    *string  `json:"poll_interval,omitempty" yaml:"poll_interval,omitempty"`
+	Confirmations   *int     `json:"confirmations,omitempty" yaml:"confirmations,omitempty"`
+	MetricsAddr     *string  `json:"metrics_addr,omitempty" yaml:"metrics_addr,omitempty"`
+
+	Alerts *AlertConfig `json:"alerts,omitempty" yaml:"alerts,omitempty"`
+}
+
+// Environment variables that override config file values
+const (
+	EnvNetwork         = "CPIMP_NETWORK"
+	EnvEventTopic      = "CPIMP_EVENT_TOPIC"
+	EnvRules           = "CPIMP_RULES"
+	EnvBlockRange      = "CPIMP_BLOCK_RANGE"
+	EnvRateLimit       = "CPIMP_RATE_LIMIT"
+	EnvConcurrency     = "CPIMP_CONCURRENCY"
+	EnvStartBlock      = "CPIMP_START_BLOCK"
+	EnvEndBlock        = "CPIMP_END_BLOCK"
+	EnvOutputFile      = "CPIMP_OUTPUT_FILE"
+	EnvOutputFormat    = "CPIMP_OUTPUT_FORMAT"
+	EnvStore           = "CPIMP_STORE"
+	EnvTargetAddresses = "CPIMP_TARGET_ADDRESSES"
+	EnvAddressFile     = "CPIMP_ADDRESS_FILE"
+	EnvBackend         = "CPIMP_BACKEND"
+	EnvRPCURL          = "CPIMP_RPC_URL"
+	EnvAPIKey          = "CPIMP_API_KEY"
+	EnvPollInterval    = "CPIMP_POLL_INTERVAL"
+	EnvConfirmations   = "CPIMP_CONFIRMATIONS"
+	EnvMetricsAddr     = "CPIMP_METRICS_ADDR"
+)
+
+var eventTopicPattern = regexp.MustCompile(`^0x[0-9a-fA-F]{64}$`)
+
+// unmarshalConfigData decodes YAML or JSON config data, picked by file extension
+func unmarshalConfigData(path string, data []byte, v interface{}) error {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		return decoder.Decode(v)
+	case ".json":
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		return decoder.Decode(v)
+	default:
+		return fmt.Errorf("unsupported config file extension %q (use .yaml, .yml or .json)", filepath.Ext(path))
+	}
+}
+
+// LoadScanConfigFile reads a YAML or JSON scan config file
+func LoadScanConfigFile(path string) (ScanConfigFile, error) {
+	var file ScanConfigFile
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return file, fmt.Errorf("failed to read config file: %v", err)
+	}
+
+	if err := unmarshalConfigData(path, data, &file); err != nil {
+		return file, fmt.Errorf("failed to parse config file %s: %v", path, err)
+	}
+
+	return file, nil
+}
+
+// Apply overlays the fields set in the file onto config
+func (f ScanConfigFile) Apply(config *ScannerConfig) error {
+	if f.TargetAddresses != nil && f.AddressFile != nil {
+		return fmt.Errorf("target_addresses and address_file are mutually exclusive")
+	}
+
+	if f.Network != nil {
+		config.Network = *f.Network
+	}
+	if f.EventTopic != nil {
+		config.EventTopic = *f.EventTopic
+	}
+	if f.Rules != nil {
+		config.Rules = f.Rules
+	}
+	if f.BlockRange != nil {
+		config.BlockRange = *f.BlockRange
+	}
+	if f.RateLimit != nil {
+		rateLimit, err := time.ParseDuration(*f.RateLimit)
+		if err != nil {
+			return fmt.Errorf("invalid rate_limit %q: %v", *f.RateLimit, err)
+		}
+		config.RateLimit = rateLimit
+	}
+	if f.PollInterval != nil {
+		pollInterval, err := time.ParseDuration(*f.PollInterval)
+		if err != nil {
+			return fmt.Errorf("invalid poll_interval %q: %v", *f.PollInterval, err)
+		}
+		config.PollInterval = pollInterval
+	}
+	if f.Concurrency != nil {
+		config.Concurrency = *f.Concurrency
+	}
+	if f.Confirmations != nil {
+		config.Confirmations = *f.Confirmations
+	}
+	if f.MetricsAddr != nil {
+		config.MetricsAddr = *f.MetricsAddr
+	}
+	if f.StartBlock != nil {
+		config.StartBlock = *f.StartBlock
+	}
+	if f.EndBlock != nil {
+		config.EndBlock = *f.EndBlock
+	}
+	if f.OutputFile != nil {
+		config.OutputFile = *f.OutputFile
+	}
+	if f.OutputFormat != nil {
+		config.OutputFormat = *f.OutputFormat
+	}
+	if f.Store != nil {
+		config.Store = *f.Store
+	}
+	if f.TargetAddresses != nil {
+		config.TargetAddresses = f.TargetAddresses
+		config.AddressFile = ""
+	}
+	if f.AddressFile != nil {
+		config.AddressFile = *f.AddressFile
+		config.TargetAddresses = nil
+	}
+	if f.Backend != nil {
+		config.Backend = *f.Backend
+	}
+	if f.RPCURL != nil {
+		config.RPCURL = *f.RPCURL
+	}
+	if f.APIKey != nil {
+		config.APIKey = *f.APIKey
+	}
+	if f.Alerts != nil {
+		config.Alerts = *f.Alerts
+	}
+
+	return nil
+}
+
+// ScanConfigFileFrom converts a ScannerConfig into its file representation
+func ScanConfigFileFrom(config ScannerConfig) ScanConfigFile {
+	rateLimit := config.RateLimit.String()
+	file := ScanConfigFile{
+		Network:         &config.Network,
+		EventTopic:      &config.EventTopic,
+		Rules:           config.Rules,
+		BlockRange:      &config.BlockRange,
+		RateLimit:       &rateLimit,
+		Concurrency:     &config.Concurrency,
+		Confirmations:   &config.Confirmations,
+		StartBlock:      &config.StartBlock,
+		EndBlock:        &config.EndBlock,
+		OutputFile:      &config.OutputFile,
+		TargetAddresses: config.TargetAddresses,
+	}
+	// Keep the file reference rather than the addresses it expanded to
+	if config.AddressFile != "" {
+		file.AddressFile = &config.AddressFile
+		file.TargetAddresses = nil
+	}
+	if config.OutputFormat != "" {
+		file.OutputFormat = &config.OutputFormat
+	}
+	if config.Store != "" {
+		file.Store = &config.Store
+	}
+	if config.Backend != "" {
+		file.Backend = &config.Backend
+	}
+	if config.RPCURL != "" {
+		file.RPCURL = &config.RPCURL
+	}
+	if config.PollInterval != 0 {
+		pollInterval := config.PollInterval.String()
+		file.PollInterval = &pollInterval
+	}
+	if config.MetricsAddr != "" {
+		file.MetricsAddr = &config.MetricsAddr
+	}
+	// Never print the key itself
+	if config.APIKey != "" {
+		redacted := "<redacted>"
+		file.APIKey = &redacted
+	}
+	if len(config.Alerts.Sinks) > 0 {
+		alerts := config.Alerts.redacted()
+		file.Alerts = &alerts
+	}
+	return file
+}
+
+// ApplyEnvOverrides overlays CPIMP_* environment variables onto config
+func ApplyEnvOverrides(config *ScannerConfig) error {
+	var file ScanConfigFile
+
+	if v, ok := os.LookupEnv(EnvNetwork); ok {
+		file.Network = &v
+	}
+	if v, ok := os.LookupEnv(EnvEventTopic); ok {
+		file.EventTopic = &v
+	}
+	if v, ok := os.LookupEnv(EnvRateLimit); ok {
+		file.RateLimit = &v
+	}
+	if v, ok := os.LookupEnv(EnvOutputFile); ok {
+		file.OutputFile = &v
+	}
+	if v, ok := os.LookupEnv(EnvOutputFormat); ok {
+		file.OutputFormat = &v
+	}
+	if v, ok := os.LookupEnv(EnvStore); ok {
+		file.Store = &v
+	}
+	if v, ok := os.LookupEnv(EnvAddressFile); ok {
+		file.AddressFile = &v
+	}
+	if v, ok := os.LookupEnv(EnvBackend); ok {
+		file.Backend = &v
+	}
+	if v, ok := os.LookupEnv(EnvRPCURL); ok {
+		file.RPCURL = &v
+	}
+	if v, ok := os.LookupEnv(EnvAPIKey); ok {
+		file.APIKey = &v
+	}
+	if v, ok := os.LookupEnv(EnvPollInterval); ok {
+		file.PollInterval = &v
+	}
+	if v, ok := os.LookupEnv(EnvMetricsAddr); ok {
+		file.MetricsAddr = &v
+	}
+	if v, ok := os.LookupEnv(EnvTargetAddresses); ok {
+		file.TargetAddresses = splitAddressList(v)
+	}
+	if v, ok := os.LookupEnv(EnvRules); ok {
+		file.Rules = splitAddressList(v)
+	}
+
+	for name, target := range map[string]**uint64{
+		EnvBlockRange: &file.BlockRange,
+		EnvStartBlock: &file.StartBlock,
+		EnvEndBlock:   &file.EndBlock,
+	} {
+		v, ok := os.LookupEnv(name)
+		if !ok {
+			continue
+		}
+		n, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid %s %q: %v", name, v, err)
+		}
+		*target = &n
+	}
+
+	for name, target := range map[string]**int{
+		EnvConcurrency:   &file.Concurrency,
+		EnvConfirmations: &file.Confirmations,
+	} {
+		v, ok := os.LookupEnv(name)
+		if !ok {
+			continue
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("invalid %s %q: %v", name, v, err)
+		}
+		*target = &n
+	}
+
+	if err := file.Apply(config); err != nil {
+		return fmt.Errorf("invalid environment overrides: %v", err)
+	}
+	return nil
+}
+
+// Finalize resolves the event topic, loads TargetAddresses from AddressFile
+// when needed and fills in the network's default block range, rate limit and
+// concurrency, confirmations, the default poll interval, the default
+// output format and file name and the alert defaults
+func (c *ScannerConfig) Finalize() {
+	network := Networks[c.Network]
+	if c.BlockRange == 0 {
+		c.BlockRange = network.DefaultBlockRange
+		if c.BlockRange == 0 {
+			c.BlockRange = FallbackBlockRange
+		}
+	}
+	if c.RateLimit == 0 {
+		c.RateLimit = network.RateLimit()
+	}
+	if c.Concurrency == 0 {
+		c.Concurrency = network.DefaultConcurrency
+		if c.Concurrency == 0 {
+			c.Concurrency = FallbackConcurrency
+		}
+	}
+	if c.PollInterval == 0 {
+		c.PollInterval = FallbackPollInterval
+	}
+	if c.Confirmations < 0 {
+		c.Confirmations = int(network.DefaultConfirmations)
+	}
+	c.Alerts.finalize()
+
+	// Event signatures and names are scanned by their topic hash
+	if topic, event, err := resolveEventTopic(c.EventTopic); err == nil {
+		c.EventTopic = topic
+		if event.Name != "" {
+			c.Event = &event
+		}
+	}
+
+	// An address file that can't be read fails Validate
+	if len(c.TargetAddresses) == 0 && c.AddressFile != "" {
+		if addresses, err := loadAddressesFromFile(c.AddressFile); err == nil {
+			c.TargetAddresses = addresses
+			logger.Info("Loaded addresses", "file", c.AddressFile, "addresses", len(addresses))
+		}
+	}
+
+	c.OutputFormat = strings.ToLower(c.OutputFormat)
+	if c.OutputFormat == "" {
+		c.OutputFormat = OutputCSV
+	}
+
+	if c.OutputFile == "" {
+		if len(c.TargetAddresses) > 0 {
+			c.OutputFile = fmt.Sprintf("%s_address_list_scan.%s", c.Network, c.OutputFormat)
+		} else {
+			c.OutputFile = fmt.Sprintf("%s_upgraded_transactions.%s", c.Network, c.OutputFormat)
+		}
+	}
+}
+
+// Validate checks that the configuration can be used for a scan
+func (c ScannerConfig) Validate() error {
+	network, exists := Networks[c.Network]
+	if !exists {
+		return fmt.Errorf("unknown network %q", c.Network)
+	}
+	backend := backendName(c, network)
+	if !isKnownBackend(backend) {
+		return fmt.Errorf("unknown backend %q", backend)
+	}
+	if backend == BackendRPC && rpcURL(c, network) == "" {
+		return fmt.Errorf("backend %q requires an RPC URL (rpc_url in the scan config or networks registry)", backend)
+	}
+	if backend == BackendBlockscout && network.BlockscoutURL == "" {
+		return fmt.Errorf("network %q has no blockscout_url; use the rpc or etherscan backend", c.Network)
+	}
+	if backend == BackendEtherscan && network.EtherscanURL == "" {
+		return fmt.Errorf("network %q has no etherscan_url in the networks registry", c.Network)
+	}
+	if !eventTopicPattern.MatchString(c.EventTopic) {
+		if _, _, err := resolveEventTopic(c.EventTopic); err != nil {
+			return fmt.Errorf("invalid event topic: %v", err)
+		}
+		return fmt.Errorf("invalid event topic %q: must be an event signature or 0x followed by 64 hex characters", c.EventTopic)
+	}
+	if err := validateRules(c.Rules); err != nil {
+		return err
+	}
+	if len(c.TargetAddresses) == 0 && c.AddressFile != "" {
+		if _, err := loadAddressesFromFile(c.AddressFile); err != nil {
+			return err
+		}
+	}
+	if c.OutputFormat != "" && !isKnownOutputFormat(c.OutputFormat) {
+		return fmt.Errorf("unknown output format %q (use csv, json or ndjson)", c.OutputFormat)
+	}
+	if c.BlockRange == 0 {
+		return fmt.Errorf("block range must be greater than 0")
+	}
+	if c.Concurrency < 0 {
+		return fmt.Errorf("concurrency must not be negative")
+	}
+	if c.Confirmations < 0 {
+		return fmt.Errorf("confirmations must not be negative")
+	}
+	if c.PollInterval < 0 {
+		return fmt.Errorf("poll interval must not be negative")
+	}
+	if c.Watch && c.EndBlock != 0 {
+		return fmt.Errorf("end block can't be set in watch mode, which follows the chain head")
+	}
+	if c.EndBlock != 0 && c.StartBlock > c.EndBlock {
+		return fmt.Errorf("start block %d is after end block %d", c.StartBlock, c.EndBlock)
+	}
+	if err := c.Alerts.validate(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// splitAddressList splits a comma-separated list (addresses, rule names),
+// dropping blanks
+func splitAddressList(list string) []string {
+	addresses := []string{}
+	for _, addr := range strings.Split(list, ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			addresses = append(addresses, addr)
+		}
+	}
+	return addresses
+}
+
+// loadAddressesFromFile reads the target addresses of an address file, one
+// per line, skipping blank lines and comments
+func loadAddressesFromFile(filename string) ([]string, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("could not open address file: %v", err)
+	}
+	defer file.Close()
+
+	var addresses []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		address := strings.TrimSpace(scanner.Text())
+		// Skip empty lines and comments
+		if address != "" && !strings.HasPrefix(address, "#") && !strings.HasPrefix(address, "//") {
+			addresses = append(addresses, address)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read address file %s: %v", filename, err)
+	}
+	return addresses, nil
+}
@@ -0,0 +1,375 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Etherscan's documented paging limits: at most 1000 records per page and
+// page * offset <= 10000 per query
+const (
+	etherscanPageSize   = 1000
+	etherscanMaxRecords = 10000
+)
+
+// getcontractcreation accepts up to 5 addresses per call
+const etherscanCreationBatchSize = 5
+
+// EtherscanSource reads chain data from an Etherscan-compatible API
+// (Etherscan, its sister explorers, and the v2 multichain API)
+type EtherscanSource struct {
+	// API endpoint, e.g. https://api.etherscan.io/api or
+	// https://api.etherscan.io/v2/api?chainid=1
+	BaseURL string
+	APIKey  string
+	Client  *APIClient
+}
+
+// etherscanResponse is the envelope of the module/action endpoints
+type etherscanResponse struct {
+	Status  string          `json:"status"`
+	Message string          `json:"message"`
+	Result  json.RawMessage `json:"result"`
+}
+
+// etherscanProxyResponse is the envelope of the module=proxy endpoints
+type etherscanProxyResponse struct {
+	Result json.RawMessage `json:"result"`
+	Error  *RPCError       `json:"error"`
+}
+
+func (e *EtherscanSource) Name() string { return BackendEtherscan }
+
+// get calls the API with the given query parameters and returns the body
+func (e *EtherscanSource) get(params url.Values) ([]byte, error) {
+	u, err := url.Parse(e.BaseURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Etherscan URL: %v", err)
+	}
+
+	// Keep parameters already in the base URL (e.g. chainid for v2)
+	query := u.Query()
+	for key, values := range params {
+		query[key] = values
+	}
+	if e.APIKey != "" {
+		query.Set("apikey", e.APIKey)
+	}
+	u.RawQuery = query.Encode()
+
+	return e.Client.GetChecked(u.String(), etherscanRateLimited)
+}
+
+// etherscanRateLimited reports whether a body is a rate limit message,
+// which Etherscan sends with status 200; the client retries those with the
+// same backoff as a 429
+func etherscanRateLimited(body []byte) bool {
+	var resp etherscanResponse
+	if json.Unmarshal(body, &resp) != nil || resp.Status != "0" {
+		return false
+	}
+	return strings.Contains(strings.ToLower(resp.detail()), "rate limit")
+}
+
+// detail returns the detail of a status "0" envelope. Errors carry it as a
+// string in result; anything else is reported as sent.
+func (resp etherscanResponse) detail() string {
+	var detail string
+	if err := json.Unmarshal(resp.Result, &detail); err != nil {
+		detail = bodySnippet(resp.Result)
+	}
+	return detail
+}
+
+// apiError converts a status "0" envelope into an error. Rate limit
+// messages don't get here: the client retries them and returns a
+// RateLimitError if they persist.
+func (e *EtherscanSource) apiError(resp etherscanResponse) error {
+	return fmt.Errorf("Etherscan API error: %s (%s)", resp.Message, resp.detail())
+}
+
+// etherscanEmptyMessages are the status "0" messages that Etherscan-family
+// APIs send for a query with no results, e.g. getcontractcreation on EOAs
+var etherscanEmptyMessages = []string{"no records found", "no data found", "no transactions found", "no logs found"}
+
+// isEmptyResult reports whether a status "0" response just means there is
+// nothing to return
+func (resp etherscanResponse) isEmptyResult() bool {
+	message := strings.ToLower(resp.Message)
+	for _, empty := range etherscanEmptyMessages {
+		if strings.Contains(message, empty) {
+			return true
+		}
+	}
+	// Errors carry their detail as a string; an empty list is no results
+	return strings.TrimSpace(string(resp.Result)) == "[]"
+}
+
+// call calls a module/action endpoint and decodes its result. A status "0"
+// response without results (see isEmptyResult) yields an empty result.
+func (e *EtherscanSource) call(params url.Values, result interface{}) error {
+	body, err := e.get(params)
+	if err != nil {
+		return err
+	}
+
+	var resp etherscanResponse
+	if err := decodeResponse(e.BaseURL, body, &resp); err != nil {
+		return err
+	}
+
+	if resp.Status != "1" {
+		if resp.isEmptyResult() {
+			return nil
+		}
+		return e.apiError(resp)
+	}
+
+	if err := decodeResponse(e.BaseURL, resp.Result, result); err != nil {
+		return err
+	}
+	return nil
+}
+
+// proxy calls a module=proxy (JSON-RPC passthrough) endpoint
+func (e *EtherscanSource) proxy(params url.Values, result interface{}) error {
+	params.Set("module", "proxy")
+	body, err := e.get(params)
+	if err != nil {
+		return err
+	}
+
+	var resp etherscanProxyResponse
+	if err := decodeResponse(e.BaseURL, body, &resp); err != nil {
+		return err
+	}
+	if resp.Error != nil {
+		return resp.Error
+	}
+
+	// Rate limit and key errors come back in the module/action envelope
+	var envelope etherscanResponse
+	if json.Unmarshal(body, &envelope) == nil && envelope.Status == "0" {
+		return e.apiError(envelope)
+	}
+
+	if err := decodeResponse(e.BaseURL, resp.Result, result); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (e *EtherscanSource) LatestBlockNumber() (uint64, error) {
+	var result string
+	if err := e.proxy(url.Values{"action": {"eth_blockNumber"}}, &result); err != nil {
+		return 0, err
+	}
+	return parseHexUint64(result)
+}
+
+// FetchLogs pages through getLogs. Etherscan filters on a single address and
+// topic0 per call, so multiple addresses are queried one after another, and
+// multiple topics through fetchAnyTopic; its unfiltered call reads a single
+// page, falling back to the topics when that page is full.
+func (e *EtherscanSource) FetchLogs(topics []string, fromBlock, toBlock uint64, addresses []string) ([]LogEntry, error) {
+	if len(addresses) == 0 {
+		addresses = []string{""}
+	}
+
+	var logs []LogEntry
+	for _, address := range addresses {
+		var targets []string
+		if address != "" {
+			targets = []string{address}
+		}
+		addressLogs, err := fetchAnyTopic(topics, targets, 0, func(topic string) ([]LogEntry, error) {
+			if topic == "" {
+				return e.fetchLogsForAddress(topic, fromBlock, toBlock, address, 1)
+			}
+			return e.fetchLogsForAddress(topic, fromBlock, toBlock, address, etherscanMaxRecords/etherscanPageSize)
+		})
+		if err != nil {
+			return nil, err
+		}
+		logs = append(logs, addressLogs...)
+	}
+	return logs, nil
+}
+
+// fetchLogsForAddress reads up to maxPages pages of getLogs results (topic ""
+// = any topic), failing with ErrLogLimitExceeded when there are more
+func (e *EtherscanSource) fetchLogsForAddress(eventTopic string, fromBlock, toBlock uint64, address string, maxPages int) ([]LogEntry, error) {
+	var logs []LogEntry
+	for page := 1; ; page++ {
+		if page > maxPages {
+			// The API can't page past 10000 records; ask for a smaller range
+			return nil, fmt.Errorf("%w: more than %d logs in blocks %d-%d", ErrLogLimitExceeded, maxPages*etherscanPageSize, fromBlock, toBlock)
+		}
+
+		params := url.Values{
+			"module":    {"logs"},
+			"action":    {"getLogs"},
+			"fromBlock": {strconv.FormatUint(fromBlock, 10)},
+			"toBlock":   {strconv.FormatUint(toBlock, 10)},
+			"page":      {strconv.Itoa(page)},
+			"offset":    {strconv.Itoa(etherscanPageSize)},
+		}
+		if eventTopic != "" {
+			params.Set("topic0", eventTopic)
+		}
+		if address != "" {
+			params.Set("address", address)
+		}
+
+		var pageLogs []LogEntry
+		if err := e.call(params, &pageLogs); err != nil {
+			return nil, err
+		}
+		logs = append(logs, pageLogs...)
+
+		if len(pageLogs) < etherscanPageSize {
+			return logs, nil
+		}
+	}
+}
+
+// LogsCap is 0: FetchLogs pages through results and reports oversized
+// ranges with ErrLogLimitExceeded instead of truncating
+func (e *EtherscanSource) LogsCap() int { return 0 }
+
+func (e *EtherscanSource) TransactionFrom(txHash string) (string, error) {
+	var tx *Transaction
+	if err := e.proxy(url.Values{"action": {"eth_getTransactionByHash"}, "txhash": {txHash}}, &tx); err != nil {
+		return "", err
+	}
+	if tx == nil {
+		return "", fmt.Errorf("transaction %s not found", txHash)
+	}
+	return tx.From, nil
+}
+
+func (e *EtherscanSource) StorageAt(address, slot string) (string, error) {
+	var word string
+	params := url.Values{"action": {"eth_getStorageAt"}, "address": {address}, "position": {slot}, "tag": {"latest"}}
+	if err := e.proxy(params, &word); err != nil {
+		return "", err
+	}
+	return word, nil
+}
+
+func (e *EtherscanSource) Call(to, data string) (string, error) {
+	var result string
+	params := url.Values{"action": {"eth_call"}, "to": {to}, "data": {data}, "tag": {"latest"}}
+	if err := e.proxy(params, &result); err != nil {
+		return "", err
+	}
+	return result, nil
+}
+
+func (e *EtherscanSource) BlockTimestamp(block uint64) (time.Time, error) {
+	var header *rpcBlockHeader
+	params := url.Values{"action": {"eth_getBlockByNumber"}, "tag": {toHexQuantity(block)}, "boolean": {"false"}}
+	if err := e.proxy(params, &header); err != nil {
+		return time.Time{}, err
+	}
+	return header.blockTime(block)
+}
+
+func (e *EtherscanSource) BlockHeader(block uint64) (BlockHeader, error) {
+	var header *rpcBlockHeader
+	params := url.Values{"action": {"eth_getBlockByNumber"}, "tag": {toHexQuantity(block)}, "boolean": {"false"}}
+	if err := e.proxy(params, &header); err != nil {
+		return BlockHeader{}, err
+	}
+	return header.blockHeader(block)
+}
+
+// transactionBlockNumber returns the block a transaction was mined in
+func (e *EtherscanSource) transactionBlockNumber(txHash string) (uint64, error) {
+	var tx *struct {
+		BlockNumber string `json:"blockNumber"`
+	}
+	if err := e.proxy(url.Values{"action": {"eth_getTransactionByHash"}, "txhash": {txHash}}, &tx); err != nil {
+		return 0, err
+	}
+	if tx == nil || tx.BlockNumber == "" {
+		return 0, fmt.Errorf("transaction %s not found", txHash)
+	}
+	return parseHexUint64(tx.BlockNumber)
+}
+
+// ContractCreation looks up a single address; scans use the batched
+// ContractCreations instead
+func (e *EtherscanSource) ContractCreation(address string) (uint64, string, error) {
+	creations, err := e.ContractCreations([]string{address})
+	if err != nil {
+		return 0, "", err
+	}
+	return lookupBatchedCreation(creations, nil, address)
+}
+
+func (e *EtherscanSource) CreationBatchSize() int { return etherscanCreationBatchSize }
+
+// ContractCreations resolves up to CreationBatchSize addresses with one
+// getcontractcreation call. Addresses missing from the result are not
+// contracts. Etherscan doesn't expose whether a contract is a proxy, so each
+// contract's EIP-1967 implementation and beacon slots are read; contracts with
+// both empty get ErrNotProxy, as they would on Blockscout.
+func (e *EtherscanSource) ContractCreations(addresses []string) (map[string]ContractCreationResult, error) {
+	var records []struct {
+		ContractAddress string `json:"contractAddress"`
+		TxHash          string `json:"txHash"`
+		BlockNumber     string `json:"blockNumber"`
+	}
+	params := url.Values{
+		"module":            {"contract"},
+		"action":            {"getcontractcreation"},
+		"contractaddresses": {strings.Join(addresses, ",")},
+	}
+	if err := e.call(params, &records); err != nil {
+		return nil, err
+	}
+
+	creations := make(map[string]ContractCreationResult)
+	for _, record := range records {
+		creation := ContractCreationResult{TxHash: record.TxHash}
+
+		// Older API versions don't return the block; look it up from the tx
+		if record.BlockNumber != "" {
+			block, err := strconv.ParseUint(record.BlockNumber, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid block number %q for %s", record.BlockNumber, record.ContractAddress)
+			}
+			creation.Block = block
+		} else if record.TxHash != "" {
+			block, err := e.transactionBlockNumber(record.TxHash)
+			if err != nil {
+				return nil, fmt.Errorf("failed to get transaction block: %v", err)
+			}
+			creation.Block = block
+		}
+
+		creation.Err = e.checkProxy(record.ContractAddress)
+		creations[strings.ToLower(record.ContractAddress)] = creation
+	}
+	return creations, nil
+}
+
+// checkProxy returns ErrNotProxy unless the contract's EIP-1967
+// implementation or beacon slot holds an address
+func (e *EtherscanSource) checkProxy(address string) error {
+	for _, slot := range []string{EIP1967ImplementationSlot, EIP1967BeaconSlot} {
+		word, err := e.StorageAt(address, slot)
+		if err != nil {
+			return fmt.Errorf("failed to read EIP-1967 slot: %w", err)
+		}
+		if _, ok := slotAddress(word); ok {
+			return nil
+		}
+	}
+	return ErrNotProxy
+}
@@ -0,0 +1,445 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/sha3"
+)
+
+// EventParam is one argument of an event
+type EventParam struct {
+	Name    string
+	Type    string
+	Indexed bool
+}
+
+// EventSignature is a parsed event declaration such as
+// "Upgraded(address indexed implementation)"
+type EventSignature struct {
+	Name   string
+	Params []EventParam
+
+	// IndexedKnown is false when the declaration didn't say which arguments
+	// are indexed (e.g. the canonical form "AdminChanged(address,address)")
+	IndexedKnown bool
+
+	// Standard the event comes from, for registry entries
+	Standard string
+}
+
+// Canonical returns the signature hashed into topic0, e.g. "Upgraded(address)"
+func (e EventSignature) Canonical() string {
+	types := make([]string, len(e.Params))
+	for i, param := range e.Params {
+		types[i] = param.Type
+	}
+	return e.Name + "(" + strings.Join(types, ",") + ")"
+}
+
+// String returns the full declaration with names and indexed markers
+func (e EventSignature) String() string {
+	params := make([]string, len(e.Params))
+	for i, param := range e.Params {
+		parts := []string{param.Type}
+		if param.Indexed {
+			parts = append(parts, "indexed")
+		}
+		if param.Name != "" {
+			parts = append(parts, param.Name)
+		}
+		params[i] = strings.Join(parts, " ")
+	}
+	return e.Name + "(" + strings.Join(params, ", ") + ")"
+}
+
+// Topic returns keccak256 of the canonical signature
+func (e EventSignature) Topic() string {
+	return eventTopic(e.Canonical())
+}
+
+// eventTopic returns keccak256 of a canonical event signature as 0x-hex
+func eventTopic(canonical string) string {
+	hash := sha3.NewLegacyKeccak256()
+	hash.Write([]byte(canonical))
+	return "0x" + hex.EncodeToString(hash.Sum(nil))
+}
+
+var (
+	eventDeclPattern = regexp.MustCompile(`^(?:event\s+)?([A-Za-z_$][A-Za-z0-9_$]*)\s*\((.*)\)\s*;?$`)
+	abiTypePattern   = regexp.MustCompile(`^(address|bool|string|bytes([1-9]|[12][0-9]|3[0-2])?|u?int(8|16|24|32|40|48|56|64|72|80|88|96|104|112|120|128|136|144|152|160|168|176|184|192|200|208|216|224|232|240|248|256)?)(\[[0-9]*\])*$`)
+)
+
+// parseEventSignature parses a human-readable event declaration. Argument
+// names and "indexed" markers are optional; "uint" and "int" are normalized
+// to their 256-bit forms as in the canonical signature.
+func parseEventSignature(decl string) (EventSignature, error) {
+	match := eventDeclPattern.FindStringSubmatch(strings.TrimSpace(decl))
+	if match == nil {
+		return EventSignature{}, fmt.Errorf("invalid event signature %q: expected Name(type,...)", decl)
+	}
+
+	event := EventSignature{Name: match[1]}
+	if strings.TrimSpace(match[2]) == "" {
+		event.IndexedKnown = true
+		return event, nil
+	}
+
+	for _, raw := range strings.Split(match[2], ",") {
+		fields := strings.Fields(raw)
+		if len(fields) == 0 || len(fields) > 3 {
+			return EventSignature{}, fmt.Errorf("invalid argument %q in event signature %q", strings.TrimSpace(raw), decl)
+		}
+
+		param := EventParam{Type: normalizeABIType(fields[0])}
+		if !abiTypePattern.MatchString(param.Type) {
+			return EventSignature{}, fmt.Errorf("unsupported type %q in event signature %q", fields[0], decl)
+		}
+		rest := fields[1:]
+		if len(rest) > 0 && rest[0] == "indexed" {
+			param.Indexed = true
+			event.IndexedKnown = true
+			rest = rest[1:]
+		}
+		if len(rest) > 1 {
+			return EventSignature{}, fmt.Errorf("invalid argument %q in event signature %q", strings.TrimSpace(raw), decl)
+		}
+		if len(rest) == 1 {
+			param.Name = rest[0]
+		}
+		event.Params = append(event.Params, param)
+	}
+	return event, nil
+}
+
+// normalizeABIType expands the uint/int aliases, keeping array suffixes
+func normalizeABIType(t string) string {
+	base, suffix := t, ""
+	if i := strings.Index(t, "["); i >= 0 {
+		base, suffix = t[:i], t[i:]
+	}
+	switch base {
+	case "uint":
+		base = "uint256"
+	case "int":
+		base = "int256"
+	}
+	return base + suffix
+}
+
+// mustParseEventSignature is parseEventSignature for the built-in registry
+func mustParseEventSignature(decl, standard string) EventSignature {
+	event, err := parseEventSignature(decl)
+	if err != nil {
+		panic(err)
+	}
+	// Registry entries spell out every indexed argument
+	event.IndexedKnown = true
+	event.Standard = standard
+	return event
+}
+
+// EventRegistry lists the proxy-related events the scanner knows, keyed by
+// canonical signature. UUPS (EIP-1822) proxies built on ERC1967Upgrade emit
+// the EIP-1967 events.
+var EventRegistry = buildEventRegistry(
+	mustParseEventSignature("Upgraded(address indexed implementation)", "EIP-1967 / EIP-1822 (UUPS)"),
+	mustParseEventSignature("AdminChanged(address previousAdmin, address newAdmin)", "EIP-1967"),
+	mustParseEventSignature("BeaconUpgraded(address indexed beacon)", "EIP-1967 beacon proxy"),
+	mustParseEventSignature("Initialized(uint8 version)", "OpenZeppelin Initializable v4"),
+	mustParseEventSignature("Initialized(uint64 version)", "OpenZeppelin Initializable v5"),
+)
+
+func buildEventRegistry(events ...EventSignature) map[string]EventSignature {
+	registry := make(map[string]EventSignature, len(events))
+	for _, event := range events {
+		registry[event.Canonical()] = event
+	}
+	return registry
+}
+
+// lookupEvent returns the registry entry for a canonical signature, or the
+// parsed declaration itself for events outside the registry
+func lookupEvent(decl string) (EventSignature, error) {
+	event, err := parseEventSignature(decl)
+	if err != nil {
+		return event, err
+	}
+	if known, ok := EventRegistry[event.Canonical()]; ok && !event.IndexedKnown {
+		return known, nil
+	}
+	return event, nil
+}
+
+// registryTopic returns the topic of a built-in event, by canonical signature
+func registryTopic(canonical string) string {
+	event, ok := EventRegistry[canonical]
+	if !ok {
+		panic("event not in registry: " + canonical)
+	}
+	return event.Topic()
+}
+
+// registryEventByTopic returns the built-in event with the given topic0
+func registryEventByTopic(topic string) (EventSignature, bool) {
+	for _, event := range EventRegistry {
+		if strings.EqualFold(event.Topic(), topic) {
+			return event, true
+		}
+	}
+	return EventSignature{}, false
+}
+
+// resolveEventTopic accepts a 0x-prefixed topic hash, a built-in event name
+// when it is unambiguous (e.g. "Upgraded") or an event signature, and
+// returns the topic hash and the event (zero for a bare topic hash). The
+// registry isn't changed; see registerEvent.
+func resolveEventTopic(value string) (string, EventSignature, error) {
+	value = strings.TrimSpace(value)
+	if eventTopicPattern.MatchString(value) {
+		return value, EventSignature{}, nil
+	}
+	if !strings.Contains(value, "(") {
+		var matches []EventSignature
+		for _, event := range EventRegistry {
+			if event.Name == value {
+				matches = append(matches, event)
+			}
+		}
+		switch len(matches) {
+		case 0:
+			return "", EventSignature{}, fmt.Errorf("unknown event %q: give a signature such as %s(address) or a 0x topic hash", value, value)
+		case 1:
+			return matches[0].Topic(), matches[0], nil
+		default:
+			return "", EventSignature{}, fmt.Errorf("event name %q is ambiguous, give the full signature (%s)", value, strings.Join(canonicalNames(matches), " or "))
+		}
+	}
+	event, err := lookupEvent(value)
+	if err != nil {
+		return "", EventSignature{}, err
+	}
+	return event.Topic(), event, nil
+}
+
+// registerEvent adds an event outside the registry so its logs can be
+// decoded. It is called once at scan start, before any worker reads the
+// registry.
+func registerEvent(event EventSignature) {
+	if _, ok := EventRegistry[event.Canonical()]; !ok {
+		EventRegistry[event.Canonical()] = event
+	}
+}
+
+func canonicalNames(events []EventSignature) []string {
+	names := make([]string, len(events))
+	for i, event := range events {
+		names[i] = event.Canonical()
+	}
+	sort.Strings(names)
+	return names
+}
+
+// decodeLogs returns every log in order with its raw fields, decoding the
+// ones whose topic0 is a known event. Unknown events, or logs that fail to
+// decode, are left without an event name and args.
+func decodeLogs(logs []LogEntry) []DecodedEvent {
+	decoded := make([]DecodedEvent, 0, len(logs))
+	for _, l := range logs {
+		raw := rawEvent(l)
+		if len(l.Topics) == 0 {
+			decoded = append(decoded, raw)
+			continue
+		}
+		event, ok := registryEventByTopic(l.Topics[0])
+		if !ok {
+			decoded = append(decoded, raw)
+			continue
+		}
+		d, err := decodeLog(event, l)
+		if err != nil {
+			detectorLogger.Error("Failed to decode log", "event", event.Name, "tx", l.TransactionHash, "err", err)
+			decoded = append(decoded, raw)
+			continue
+		}
+		decoded = append(decoded, d)
+	}
+	return decoded
+}
+
+// rawEvent returns a log without decoded fields
+func rawEvent(l LogEntry) DecodedEvent {
+	index, _ := l.Index()
+	topics := l.Topics
+	if topics == nil {
+		topics = []string{}
+	}
+	return DecodedEvent{
+		LogIndex: index,
+		Address:  l.Address,
+		Topics:   topics,
+		Data:     l.Data,
+		Args:     []DecodedArg{},
+	}
+}
+
+// ListEvents prints the event registry
+func ListEvents() {
+	signatures := make([]string, 0, len(EventRegistry))
+	for canonical := range EventRegistry {
+		signatures = append(signatures, canonical)
+	}
+	sort.Strings(signatures)
+
+	for _, canonical := range signatures {
+		event := EventRegistry[canonical]
+		fmt.Printf("%s:\n", event)
+		fmt.Printf("  Topic: %s\n", event.Topic())
+		fmt.Printf("  Standard: %s\n", event.Standard)
+		fmt.Println()
+	}
+}
+
+// DecodedArg is one decoded event argument
+type DecodedArg struct {
+	Name  string `json:"name"`
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+// DecodedEvent is a log with its raw fields and, when its event is known,
+// the event name, canonical signature and decoded args
+type DecodedEvent struct {
+	LogIndex  uint64       `json:"log_index"`
+	Address   string       `json:"address"`
+	Topics    []string     `json:"topics"`
+	Data      string       `json:"data"`
+	Event     string       `json:"event"`
+	Signature string       `json:"signature"`
+	Args      []DecodedArg `json:"args"`
+}
+
+// String formats the event as Name(arg=value, ...)
+func (d DecodedEvent) String() string {
+	args := make([]string, len(d.Args))
+	for i, arg := range d.Args {
+		args[i] = arg.Name + "=" + arg.Value
+	}
+	return d.Event + "(" + strings.Join(args, ", ") + ")"
+}
+
+// decodeLog decodes the indexed arguments from the topics and the others
+// from the data. When the signature doesn't say which arguments are
+// indexed, the leading arguments are assumed to fill the topics. Indexed
+// dynamic values are only available as their hash.
+func decodeLog(event EventSignature, l LogEntry) (DecodedEvent, error) {
+	decoded := rawEvent(l)
+	decoded.Event = event.Name
+	decoded.Signature = event.Canonical()
+
+	indexed := make([]bool, len(event.Params))
+	for i, param := range event.Params {
+		indexed[i] = param.Indexed || (!event.IndexedKnown && i < len(l.Topics)-1)
+	}
+
+	data, err := hex.DecodeString(strings.TrimPrefix(l.Data, "0x"))
+	if err != nil {
+		return decoded, fmt.Errorf("invalid log data: %v", err)
+	}
+
+	topic, slot := 1, 0
+	for i, param := range event.Params {
+		name := param.Name
+		if name == "" {
+			name = "arg" + strconv.Itoa(i)
+		}
+		arg := DecodedArg{Name: name, Type: param.Type}
+
+		if indexed[i] {
+			if topic >= len(l.Topics) {
+				return decoded, fmt.Errorf("%s: missing topic for indexed argument %s", event.Canonical(), name)
+			}
+			word, err := hex.DecodeString(strings.TrimPrefix(l.Topics[topic], "0x"))
+			if err != nil || len(word) != 32 {
+				return decoded, fmt.Errorf("%s: invalid topic %q", event.Canonical(), l.Topics[topic])
+			}
+			topic++
+			if isDynamicType(param.Type) {
+				arg.Value = "0x" + hex.EncodeToString(word)
+			} else {
+				arg.Value = decodeStaticWord(param.Type, word)
+			}
+		} else {
+			if (slot+1)*32 > len(data) {
+				return decoded, fmt.Errorf("%s: data too short for argument %s", event.Canonical(), name)
+			}
+			word := data[slot*32 : (slot+1)*32]
+			slot++
+			if isDynamicType(param.Type) {
+				arg.Value = decodeDynamic(param.Type, data, word)
+			} else {
+				arg.Value = decodeStaticWord(param.Type, word)
+			}
+		}
+		decoded.Args = append(decoded.Args, arg)
+	}
+	return decoded, nil
+}
+
+// isDynamicType reports whether values of the type are stored out of line
+// (fixed-size arrays of static types are treated as dynamic for simplicity)
+func isDynamicType(t string) bool {
+	return t == "string" || t == "bytes" || strings.Contains(t, "[")
+}
+
+// decodeStaticWord formats a 32-byte word of a static type
+func decodeStaticWord(t string, word []byte) string {
+	switch {
+	case t == "address":
+		return "0x" + hex.EncodeToString(word[12:])
+	case t == "bool":
+		return strconv.FormatBool(word[31] != 0)
+	case strings.HasPrefix(t, "uint"):
+		return new(big.Int).SetBytes(word).String()
+	case strings.HasPrefix(t, "int"):
+		n := new(big.Int).SetBytes(word)
+		if word[0]&0x80 != 0 {
+			n.Sub(n, new(big.Int).Lsh(big.NewInt(1), 256))
+		}
+		return n.String()
+	case strings.HasPrefix(t, "bytes"):
+		size, _ := strconv.Atoi(strings.TrimPrefix(t, "bytes"))
+		return "0x" + hex.EncodeToString(word[:size])
+	}
+	return "0x" + hex.EncodeToString(word)
+}
+
+// decodeDynamic decodes a string or bytes value from its offset word;
+// arrays are returned as their raw offset
+func decodeDynamic(t string, data, offsetWord []byte) string {
+	if t != "string" && t != "bytes" {
+		return "0x" + hex.EncodeToString(offsetWord)
+	}
+	// Bounds are checked without adding to the decoded values, which a
+	// crafted log can set near 2^64
+	size := uint64(len(data))
+	offset := new(big.Int).SetBytes(offsetWord)
+	if !offset.IsUint64() || size < 32 || offset.Uint64() > size-32 {
+		return "0x" + hex.EncodeToString(offsetWord)
+	}
+	start := offset.Uint64()
+	length := new(big.Int).SetBytes(data[start : start+32])
+	if !length.IsUint64() || length.Uint64() > size-start-32 {
+		return "0x" + hex.EncodeToString(offsetWord)
+	}
+	value := data[start+32 : start+32+length.Uint64()]
+	if t == "string" {
+		return strconv.Quote(string(value))
+	}
+	return "0x" + hex.EncodeToString(value)
+}
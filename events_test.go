@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// abiWord encodes n as a 32-byte big-endian word in hex
+func abiWord(n uint64) string {
+	return fmt.Sprintf("%064x", n)
+}
+
+func TestDecodeLogDynamic(t *testing.T) {
+	event, err := parseEventSignature("event Note(string note)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	topics := []string{event.Topic()}
+	maxWord := abiWord(^uint64(0))
+	hello := "68656c6c6f" + strings.Repeat("0", 54)
+
+	tests := []struct {
+		name string
+		data string
+		want string
+	}{
+		{"string", "0x" + abiWord(32) + abiWord(5) + hello, `"hello"`},
+		{"huge offset", "0x" + maxWord + abiWord(5) + hello, "0x" + maxWord},
+		{"offset past data", "0x" + abiWord(64) + abiWord(5), "0x" + abiWord(64)},
+		{"huge length", "0x" + abiWord(32) + maxWord + hello, "0x" + abiWord(32)},
+		{"length past data", "0x" + abiWord(32) + abiWord(33) + hello, "0x" + abiWord(32)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decoded, err := decodeLog(event, LogEntry{Topics: topics, Data: tt.data})
+			if err != nil {
+				t.Fatalf("decodeLog: %v", err)
+			}
+			if len(decoded.Args) != 1 || decoded.Args[0].Value != tt.want {
+				t.Fatalf("got %+v, want value %s", decoded.Args, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// resultsCSVHeader is the column layout of the results CSV
+var resultsCSVHeader = []string{"Transaction Hash", "Explorer Link", "From Address", "Block Number", "Proxy Address", "Implementation Count", "Implementations", "Current Implementation Chain", "Severity", "Rule", "Events", "Status"}
+
+// ImplementationChange is one implementation (Upgraded) or beacon
+// (BeaconUpgraded) installed within a transaction
+type ImplementationChange struct {
+	LogIndex       uint64 `json:"log_index"`
+	Implementation string `json:"implementation"`
+	Beacon         bool   `json:"beacon,omitempty"` // Implementation is the address of a beacon
+}
+
+// Finding is a transaction that matched a detection rule for a proxy, with
+// the implementations it installed in log order. Its JSON form is the
+// versioned findings record (see FindingsSchemaVersion).
+type Finding struct {
+	ScanID       string     `json:"scan_id"`
+	Network      string     `json:"network"`
+	ChainID      uint64     `json:"chain_id"`
+	Rule         string     `json:"rule"`
+	Severity     string     `json:"severity"`
+	Proxy        string     `json:"proxy"`
+	TxHash       string     `json:"tx_hash"`
+	BlockNumber  uint64     `json:"block_number"`
+	Timestamp    *time.Time `json:"timestamp"` // nil when the backend can't tell
+	From         string     `json:"from"`
+	ExplorerLink string     `json:"explorer_link"`
+
+	// Events are the proxy's logs in the transaction in log order, decoded
+	// against the event registry
+	Events []DecodedEvent `json:"events"`
+
+	Implementations []ImplementationChange `json:"implementations"`
+
+	// ImplementationChain is the proxy's EIP-1967 implementation at the
+	// latest block (not at BlockNumber) followed to the terminal logic
+	// contract
+	ImplementationChain []string `json:"implementation_chain"`
+}
+
+// Index returns the position of the log within its block. Etherscan-style
+// APIs encode index 0 as a bare "0x".
+func (l LogEntry) Index() (uint64, error) {
+	switch l.LogIndex {
+	case "", "0x":
+		return 0, nil
+	}
+	if strings.HasPrefix(l.LogIndex, "0x") {
+		return parseHexUint64(l.LogIndex)
+	}
+	return strconv.ParseUint(l.LogIndex, 10, 64)
+}
+
+// implementationArg returns the position of the address argument named
+// "implementation" or "beacon" in an event, and whether it is a beacon
+func implementationArg(event EventSignature) (index int, beacon bool, ok bool) {
+	for i, param := range event.Params {
+		if param.Type != "address" {
+			continue
+		}
+		switch param.Name {
+		case "implementation":
+			return i, false, true
+		case "beacon":
+			return i, true, true
+		}
+	}
+	return 0, false, false
+}
+
+// implementationChanges decodes the implementations and beacons installed
+// by the logs of one transaction and returns them ordered by log index. Only
+// events whose registry entry has an implementation or beacon address
+// argument are decoded; logs of such events that can't be decoded are kept
+// with an empty address so the count still matches the events seen.
+func implementationChanges(txLogs []LogEntry) []ImplementationChange {
+	changes := make([]ImplementationChange, 0, len(txLogs))
+	for _, txLog := range txLogs {
+		if len(txLog.Topics) == 0 {
+			continue
+		}
+		event, ok := registryEventByTopic(txLog.Topics[0])
+		if !ok {
+			continue
+		}
+		arg, beacon, ok := implementationArg(event)
+		if !ok {
+			continue
+		}
+
+		index, err := txLog.Index()
+		if err != nil {
+			detectorLogger.Error("Invalid log index", "log_index", txLog.LogIndex, "tx", txLog.TransactionHash, "err", err)
+		}
+		change := ImplementationChange{LogIndex: index, Beacon: beacon}
+		if decoded, err := decodeLog(event, txLog); err != nil {
+			detectorLogger.Error("Failed to decode event", "event", event.Name, "tx", txLog.TransactionHash, "err", err)
+		} else {
+			change.Implementation = decoded.Args[arg].Value
+		}
+		changes = append(changes, change)
+	}
+	sort.SliceStable(changes, func(i, j int) bool {
+		return changes[i].LogIndex < changes[j].LogIndex
+	})
+	return changes
+}
+
+// ImplementationList returns the implementations in installation order,
+// beacons prefixed with "beacon:"
+func (f Finding) ImplementationList() []string {
+	list := make([]string, len(f.Implementations))
+	for i, change := range f.Implementations {
+		list[i] = change.Implementation
+		if change.Beacon {
+			list[i] = "beacon:" + change.Implementation
+		}
+	}
+	return list
+}
+
+// EventList formats the decoded events in log order, separated by "; ";
+// logs of unknown events are left out
+func (f Finding) EventList() string {
+	var events []string
+	for _, event := range f.Events {
+		if event.Event != "" {
+			events = append(events, event.String())
+		}
+	}
+	return strings.Join(events, "; ")
+}
+
+// CSVRow returns the finding in the resultsCSVHeader layout; implementations
+// are joined with " > " in the order they were installed, and the chain with
+// " -> " from the proxy's implementation to the terminal one. Status is empty;
+// it is "retracted" on the row withdrawing a reorged-out finding.
+func (f Finding) CSVRow() []string {
+	return []string{
+		f.TxHash,
+		f.ExplorerLink,
+		f.From,
+		fmt.Sprintf("0x%x", f.BlockNumber), // hex, as the CSV always had it
+		f.Proxy,
+		strconv.Itoa(len(f.Implementations)),
+		strings.Join(f.ImplementationList(), " > "),
+		strings.Join(f.ImplementationChain, " -> "),
+		f.Severity,
+		f.Rule,
+		f.EventList(),
+		"",
+	}
+}
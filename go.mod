@@ -1,3 +1,11 @@
 module cpimp-scanner
 
-go 1.21 
\ No newline at end of file
+go 1.21
+
+require gopkg.in/yaml.v3 v3.0.1
+
+require (
+	github.com/mattn/go-sqlite3 v1.14.22
+	golang.org/x/crypto v0.17.0
+	golang.org/x/sys v0.15.0 // indirect
+)
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// EIP1967ImplementationSlot is bytes32(uint256(keccak256("eip1967.proxy.implementation")) - 1)
+const EIP1967ImplementationSlot = "0x360894a13ba1a3210667c828492db98dca3e2076cc3735a920a3ca505d382bbc"
+
+// EIP1967BeaconSlot is bytes32(uint256(keccak256("eip1967.proxy.beacon")) - 1)
+const EIP1967BeaconSlot = "0xa3f0ad74e5423aebfd80d3ef4346578335a9a72aeaee59ff6cb3582b35133d50"
+
+// beaconImplementationCall is the calldata of a beacon's implementation()
+const beaconImplementationCall = "0x5c60da1b"
+
+// maxImplementationDepth bounds how far an implementation chain is followed
+const maxImplementationDepth = 8
+
+// Finding severities. A finding gets its rule's severity, raised to high when
+// the proxy's implementation is itself a proxy, the signature of a
+// proxy-in-the-middle insertion.
+const (
+	SeverityMedium = "medium"
+	SeverityHigh   = "high"
+)
+
+// slotAddress decodes an address stored in a 32-byte storage word. It returns
+// false for an empty slot or a word that doesn't hold an address.
+func slotAddress(word string) (string, bool) {
+	hex := strings.TrimPrefix(strings.ToLower(word), "0x")
+	if len(hex) > 64 {
+		return "", false
+	}
+	hex = strings.Repeat("0", 64-len(hex)) + hex
+	if strings.Trim(hex[:24], "0") != "" || strings.Trim(hex[24:], "0") == "" {
+		return "", false
+	}
+	return "0x" + hex[24:], true
+}
+
+// resolveImplementationChain follows the EIP-1967 implementation slot from
+// the proxy to the terminal logic contract, as of the latest block. A beacon
+// proxy (empty implementation slot, beacon slot set) continues with its
+// beacon's implementation(). The chain lists each hop's implementation; a
+// chain longer than one means an implementation is itself a proxy that
+// delegatecalls onward.
+func resolveImplementationChain(source ChainSource, proxy string) ([]string, error) {
+	var chain []string
+	visited := map[string]bool{strings.ToLower(proxy): true}
+
+	current := proxy
+	for len(chain) < maxImplementationDepth {
+		implementation, ok, err := proxyImplementation(source, current)
+		if err != nil || !ok {
+			return chain, err
+		}
+		chain = append(chain, implementation)
+		if visited[implementation] {
+			detectorLogger.Warn("Implementation chain loops", "address", proxy, "implementation", implementation)
+			return chain, nil
+		}
+		visited[implementation] = true
+		current = implementation
+	}
+
+	detectorLogger.Warn("Implementation chain is too deep, stopped following it", "address", proxy, "max_depth", maxImplementationDepth)
+	return chain, nil
+}
+
+// proxyImplementation returns the current implementation of an EIP-1967
+// proxy, read from its implementation slot or, for a beacon proxy, from its
+// beacon. It returns false when the address isn't such a proxy.
+func proxyImplementation(source ChainSource, proxy string) (string, bool, error) {
+	word, err := source.StorageAt(proxy, EIP1967ImplementationSlot)
+	if err != nil {
+		return "", false, fmt.Errorf("failed to read implementation slot of %s: %v", proxy, err)
+	}
+	if implementation, ok := slotAddress(word); ok {
+		return implementation, true, nil
+	}
+
+	word, err = source.StorageAt(proxy, EIP1967BeaconSlot)
+	if err != nil {
+		return "", false, fmt.Errorf("failed to read beacon slot of %s: %v", proxy, err)
+	}
+	beacon, ok := slotAddress(word)
+	if !ok {
+		return "", false, nil
+	}
+	result, err := source.Call(beacon, beaconImplementationCall)
+	if err != nil {
+		return "", false, fmt.Errorf("failed to call implementation() of beacon %s: %v", beacon, err)
+	}
+	implementation, ok := slotAddress(result)
+	return implementation, ok, nil
+}
+
+// implementationChain returns the proxy's current chain, cached for the
+// rest of the session since it reflects the latest block rather than the
+// finding's
+func (s *scanSession) implementationChain(proxy string) []string {
+	key := strings.ToLower(proxy)
+	s.mu.Lock()
+	chain, ok := s.chains[key]
+	s.mu.Unlock()
+	if ok {
+		return chain
+	}
+
+	chain, err := resolveImplementationChain(s.source, proxy)
+	if err != nil {
+		detectorLogger.Error("Failed to resolve implementation chain", "address", proxy, "err", err)
+		// Keep what was resolved, but try again for the next finding
+		return chain
+	}
+	s.mu.Lock()
+	s.chains[key] = chain
+	s.mu.Unlock()
+	return chain
+}
+
+// severityOf returns the severity of a finding of the rule with the given
+// implementation chain
+func severityOf(rule Rule, chain []string) string {
+	if len(chain) > 1 {
+		return SeverityHigh
+	}
+	return rule.Severity
+}
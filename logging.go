@@ -0,0 +1,254 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+	"sync/atomic"
+)
+
+// Log components; each can be given its own level in LOG_LEVEL
+const (
+	componentScanner  = "scanner"  // scan flow, progress files and the store
+	componentHTTP     = "http"     // API client, rate limiting and backends
+	componentDetector = "detector" // rules, event decoding and findings
+	componentProgress = "progress" // the human progress display
+)
+
+var logComponents = []string{componentScanner, componentHTTP, componentDetector, componentProgress}
+
+// Log output formats
+const (
+	LogFormatText = "text"
+	LogFormatJSON = "json"
+)
+
+// Environment variables configuring logging; -log-level and -log-format
+// override them
+const (
+	EnvLogLevel  = "LOG_LEVEL"
+	EnvLogFormat = "LOG_FORMAT"
+)
+
+// Loggers of each component. Logs and the progress display go to stderr,
+// leaving stdout to command output. The loggers are never replaced, so
+// goroutines can use them while the configuration changes.
+var (
+	logger         = newComponentLogger(componentScanner)
+	httpLogger     = newComponentLogger(componentHTTP)
+	detectorLogger = newComponentLogger(componentDetector)
+	progressLogger = newComponentLogger(componentProgress)
+)
+
+// componentHandlers holds the handler behind each component's logger
+var componentHandlers = make(map[string]*componentHandler)
+
+// logSettings is the current logging configuration
+var logSettings = struct {
+	format string
+	levels map[string]slog.Level
+	attrs  []any // added to every record, e.g. the scan ID
+}{format: LogFormatText}
+
+var logOutput io.Writer = os.Stderr
+
+func newComponentLogger(component string) *slog.Logger {
+	h := &componentHandler{}
+	h.current.Store(newLogHandler(component, slog.LevelInfo))
+	componentHandlers[component] = h
+	return slog.New(h)
+}
+
+// newLogHandler builds a component's handler for the current settings
+func newLogHandler(component string, level slog.Level) *slog.Handler {
+	opts := &slog.HandlerOptions{Level: level}
+	var handler slog.Handler
+	if logSettings.format == LogFormatJSON {
+		handler = slog.NewJSONHandler(logOutput, opts)
+	} else {
+		handler = slog.NewTextHandler(logOutput, opts)
+	}
+	attrs := append([]any{"component", component}, logSettings.attrs...)
+	handler = slog.New(handler).With(attrs...).Handler()
+	return &handler
+}
+
+// componentHandler passes records to a component's current handler, which
+// rebuildLoggers swaps atomically while other goroutines may be logging
+type componentHandler struct {
+	current atomic.Pointer[slog.Handler]
+}
+
+func (h *componentHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return (*h.current.Load()).Enabled(ctx, level)
+}
+
+func (h *componentHandler) Handle(ctx context.Context, record slog.Record) error {
+	return (*h.current.Load()).Handle(ctx, record)
+}
+
+func (h *componentHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return (*h.current.Load()).WithAttrs(attrs)
+}
+
+func (h *componentHandler) WithGroup(name string) slog.Handler {
+	return (*h.current.Load()).WithGroup(name)
+}
+
+// parseLogLevel parses a level name: debug, info, warn or error
+func parseLogLevel(name string) (slog.Level, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "", "info":
+		return slog.LevelInfo, nil
+	case "warn", "warning":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	}
+	return 0, fmt.Errorf("unknown log level %q (want debug, info, warn or error)", name)
+}
+
+// parseLogLevels parses a LOG_LEVEL value: a default level optionally
+// followed by per-component overrides, e.g. "info,http=debug,progress=warn".
+// The progress display stays at info unless set explicitly, so it shows at
+// any default level.
+func parseLogLevels(spec string) (map[string]slog.Level, error) {
+	levels := make(map[string]slog.Level)
+	defaultLevel := slog.LevelInfo
+	overrides := make(map[string]slog.Level)
+	for _, item := range strings.Split(spec, ",") {
+		component, name, ok := strings.Cut(item, "=")
+		if !ok {
+			level, err := parseLogLevel(item)
+			if err != nil {
+				return nil, err
+			}
+			defaultLevel = level
+			continue
+		}
+		component = strings.ToLower(strings.TrimSpace(component))
+		if !isLogComponent(component) {
+			return nil, fmt.Errorf("unknown log component %q (want one of %s)", component, strings.Join(logComponents, ", "))
+		}
+		level, err := parseLogLevel(name)
+		if err != nil {
+			return nil, err
+		}
+		overrides[component] = level
+	}
+
+	for _, component := range logComponents {
+		levels[component] = defaultLevel
+	}
+	if defaultLevel > slog.LevelInfo {
+		levels[componentProgress] = slog.LevelInfo
+	}
+	for component, level := range overrides {
+		levels[component] = level
+	}
+	return levels, nil
+}
+
+func isLogComponent(name string) bool {
+	for _, component := range logComponents {
+		if component == name {
+			return true
+		}
+	}
+	return false
+}
+
+// configureLogging sets the log levels (see parseLogLevels) and the output
+// format (text or json)
+func configureLogging(levelSpec, format string) error {
+	levels, err := parseLogLevels(levelSpec)
+	if err != nil {
+		return err
+	}
+	switch format = strings.ToLower(strings.TrimSpace(format)); format {
+	case "":
+		format = LogFormatText
+	case LogFormatText, LogFormatJSON:
+	default:
+		return fmt.Errorf("unknown log format %q (want text or json)", format)
+	}
+	logSettings.levels = levels
+	logSettings.format = format
+	rebuildLoggers()
+	return nil
+}
+
+// setLogScan adds the scan's ID and network to every record from now on
+func setLogScan(scanID, network string) {
+	logSettings.attrs = []any{"scan_id", scanID, "network", network}
+	rebuildLoggers()
+}
+
+func rebuildLoggers() {
+	level := func(component string) slog.Level {
+		if level, ok := logSettings.levels[component]; ok {
+			return level
+		}
+		return slog.LevelInfo
+	}
+	for component, h := range componentHandlers {
+		h.current.Store(newLogHandler(component, level(component)))
+	}
+}
+
+// describeLogLevels renders the effective levels, for the startup record
+func describeLogLevels() string {
+	var parts []string
+	for _, component := range logComponents {
+		level, ok := logSettings.levels[component]
+		if !ok {
+			level = slog.LevelInfo
+		}
+		parts = append(parts, component+"="+strings.ToLower(level.String()))
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, ",")
+}
+
+// logEnabled reports whether l logs records of level, to skip building
+// detailed output nobody will see
+func logEnabled(l *slog.Logger, level slog.Level) bool {
+	return l.Enabled(context.Background(), level)
+}
+
+// fatal logs an error and exits
+func fatal(msg string, args ...any) {
+	logger.Error(msg, args...)
+	os.Exit(1)
+}
+
+// progressf prints to the human progress display on stderr. With the JSON
+// log format each call becomes a progress record instead, so stderr stays
+// parseable. Silenced by LOG_LEVEL=progress=warn (or error).
+func progressf(format string, args ...any) {
+	if !logEnabled(progressLogger, slog.LevelInfo) {
+		return
+	}
+	if logSettings.format == LogFormatJSON {
+		if msg := strings.TrimSpace(fmt.Sprintf(format, args...)); msg != "" {
+			progressLogger.Info(msg)
+		}
+		return
+	}
+	fmt.Fprintf(logOutput, format, args...)
+}
+
+// progressOutput writes to the progress display, for output shared with
+// commands that print to stdout
+type progressOutput struct{}
+
+func (progressOutput) Write(p []byte) (int, error) {
+	progressf("%s", p)
+	return len(p), nil
+}
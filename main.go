@@ -2,51 +2,31 @@ package main
 
 import (
 	"crypto/sha256"
-	"encoding/csv"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
-	"log"
-	"net/http"
+	"log/slog"
 	"os"
+	"path/filepath"
 	"sort"
 	"strconv"
 	"strings"
 	"time"
 )
 
-// Logging levels
-const (
-	LOG_ERROR = 0
-	LOG_INFO  = 1
-	LOG_DEBUG = 2
-)
-
-var logLevel = LOG_INFO // Default to INFO level
-
-func logDebug(format string, args ...interface{}) {
-	if logLevel >= LOG_DEBUG {
-		log.Printf("[DEBUG] "+format, args...)
-	}
-}
-
-func logInfo(format string, args ...interface{}) {
-	if logLevel >= LOG_INFO {
-		log.Printf("[INFO] "+format, args...)
-	}
-}
-
-func logError(format string, args ...interface{}) {
-	if logLevel >= LOG_ERROR {
-		log.Printf("[ERROR] "+format, args...)
-	}
-}
-
 type LogEntry struct {
-	TransactionHash string `json:"transactionHash"`
-	BlockNumber     string `json:"blockNumber"`
-	Address         string `json:"address"`
+	TransactionHash string   `json:"transactionHash"`
+	BlockNumber     string   `json:"blockNumber"`
+	Address         string   `json:"address"`
+	Topics          []string `json:"topics"`
+	Data            string   `json:"data"`
+	LogIndex        string   `json:"logIndex"`
+
+	// Block time, as returned by Blockscout and Etherscan (timeStamp) or by
+	// nodes that extend eth_getLogs with it (blockTimestamp)
+	TimeStamp      string `json:"timeStamp"`
+	BlockTimestamp string `json:"blockTimestamp"`
 }
 
 type Transaction struct {
@@ -90,6 +70,14 @@ func generateScanID(config ScannerConfig) string {
 	hasher.Write([]byte(config.Network))
 	hasher.Write([]byte(config.EventTopic))
 
+	// Hash an explicit rule selection; the default (all rules) adds nothing
+	// so scans started before rules existed keep their ID
+	if len(config.Rules) > 0 {
+		sortedRules := append([]string{}, config.Rules...)
+		sort.Strings(sortedRules)
+		hasher.Write([]byte("rules:" + strings.Join(sortedRules, ",")))
+	}
+
 	// Hash target addresses (sort first for consistency)
 	if len(config.TargetAddresses) > 0 {
 		sortedAddresses := make([]string, len(config.TargetAddresses))
@@ -116,44 +104,79 @@ func getProgressFileName(scanID string) string {
 	return fmt.Sprintf("scan_progress_%s.json", scanID)
 }
 
+// getCompletedProgressFileName is where the progress of a completed scan
+// with skipped addresses is kept, so scan reclassify can still look them up
+func getCompletedProgressFileName(scanID string) string {
+	return fmt.Sprintf("scan_completed_%s.json", scanID)
+}
+
 // ContractInfo holds information about a contract
 type ContractInfo struct {
 	Address       string `json:"address"`
 	CreationBlock uint64 `json:"creation_block"`
 	CreationTx    string `json:"creation_tx"`
 	Processed     bool   `json:"processed"`
+
+	// Status is the address's classification (see StatusValid); only valid
+	// addresses are scanned. StatusError holds why an api_error lookup failed.
+	Status      string `json:"status,omitempty"`
+	StatusError string `json:"status_error,omitempty"`
+
+	// SweepComplete is set once every chunk has been attempted; the address
+	// is only Processed when the sweep is complete and FailedRanges is empty
+	SweepComplete bool          `json:"sweep_complete"`
+	FailedRanges  []FailedRange `json:"failed_ranges,omitempty"`
+
+	// Last block of the most recent chunk swept for this address (0 = none yet)
+	LastScannedBlock uint64 `json:"last_scanned_block"`
+
+	// Most recent transaction that upgraded the proxy, for rules comparing
+	// later transactions against it, and its block (for reorg rollback)
+	LastUpgradeTx    string `json:"last_upgrade_tx,omitempty"`
+	LastUpgradeBlock uint64 `json:"last_upgrade_block,omitempty"`
 }
 
 // AddressProgress tracks progress for individual addresses
 type AddressProgress struct {
-	Addresses    map[string]ContractInfo `json:"addresses"`
-	ScanID       string                  `json:"scan_id"`
-	Network      string                  `json:"network"`
-	EventTopic   string                  `json:"event_topic"`
-	LastUpdated  time.Time               `json:"last_updated"`
-	TotalLogs    int                     `json:"total_logs"`
-	DuplicateTxs int                     `json:"duplicate_txs"`
-	ProcessedTxs int                     `json:"processed_txs"`
+	SchemaVersion   int                     `json:"schema_version"` // see progressSchemaVersion
+	Addresses       map[string]ContractInfo `json:"addresses"`
+	ScanID          string                  `json:"scan_id"`
+	Network         string                  `json:"network"`
+	EventTopic      string                  `json:"event_topic"`
+	Rules           []string                `json:"rules,omitempty"`
+	LastUpdated     time.Time               `json:"last_updated"`
+	TotalLogs       int                     `json:"total_logs"`
+	DuplicateTxs    int                     `json:"duplicate_txs"`
+	ProcessedTxs    int                     `json:"processed_txs"`
+	HighSeverityTxs int                     `json:"high_severity_txs"` // implementation is itself a proxy
+	RuleFindings    map[string]int          `json:"rule_findings,omitempty"`
+	RangeStats      RangeStats              `json:"range_stats"`
+	OutputFile      string                  `json:"output_file"`
+	OutputFormat    string                  `json:"output_format,omitempty"`
+	Backend         string                  `json:"backend,omitempty"`
+
+	// Last block watch mode has followed the chain through (0 = not watched)
+	HeadBlock uint64 `json:"head_block,omitempty"`
+
+	// Hashes of the most recently scanned blocks and the findings made in
+	// them, to detect and undo reorgs near the chain head
+	RecentBlocks   []BlockRef `json:"recent_blocks,omitempty"`
+	RecentFindings []Finding  `json:"recent_findings,omitempty"`
 }
 
 // getContractCreationBlock fetches the creation block for a contract address using Blockscout v2 API
-func getContractCreationBlock(blockscoutURL, address string) (uint64, string, error) {
+func getContractCreationBlock(client *APIClient, blockscoutURL, address string) (uint64, string, error) {
 	// First, get the contract info to find creation transaction hash
 	url := fmt.Sprintf("%s/api/v2/addresses/%s", blockscoutURL, address)
 
-	resp, err := http.Get(url)
-	if err != nil {
-		return 0, "", fmt.Errorf("failed to fetch contract info: %v", err)
+	body, err := client.Get(url)
+	var notFound *NotFoundError
+	if errors.As(err, &notFound) {
+		// Blockscout has never indexed the address, so nothing was deployed there
+		return 0, "", ErrNotContract
 	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != 200 {
-		return 0, "", fmt.Errorf("API returned status %d", resp.StatusCode)
-	}
-
-	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return 0, "", fmt.Errorf("failed to read response: %v", err)
+		return 0, "", fmt.Errorf("failed to fetch contract info: %w", err)
 	}
 
 	// Parse address response
@@ -167,66 +190,51 @@ func getContractCreationBlock(blockscoutURL, address string) (uint64, string, er
 	}
 
 	// Debug: Log the raw API response
-	logDebug("Address API Response for %s: %s", address, string(body))
+	httpLogger.Debug("Address API response", "address", address, "body", string(body))
 
-	if err := json.Unmarshal(body, &addressInfo); err != nil {
-		return 0, "", fmt.Errorf("failed to parse address response: %v", err)
+	if err := decodeResponse(url, body, &addressInfo); err != nil {
+		return 0, "", fmt.Errorf("failed to parse address response: %w", err)
 	}
 
 	// Debug: Log parsed fields
-	logDebug("Parsed for %s: is_contract=%t, creation_tx=%s, implementations=%d",
-		address, addressInfo.IsContract, addressInfo.CreationTransactionHash, len(addressInfo.Implementations))
+	httpLogger.Debug("Parsed address info", "address", address, "is_contract", addressInfo.IsContract,
+		"creation_tx", addressInfo.CreationTransactionHash, "implementations", len(addressInfo.Implementations))
 
 	// Check if this is a smart contract
 	if !addressInfo.IsContract {
-		return 0, "", fmt.Errorf("address is not a smart contract (is_contract: false)")
+		return 0, "", ErrNotContract
 	}
 
 	// Check if this is a proxy contract (has implementations array with at least one entry)
 	if len(addressInfo.Implementations) == 0 {
-		return 0, "", fmt.Errorf("not a proxy contract (no implementations found)")
+		return 0, "", ErrNotProxy
 	}
 
 	// Check for valid creation transaction hash
 	if addressInfo.CreationTransactionHash == "" {
-		return 0, "", fmt.Errorf("no creation transaction found")
+		return 0, "", ErrNoCreationTx
 	}
 
 	// Now get the transaction details to find the block number
-	blockNumber, err := getTransactionBlockNumber(blockscoutURL, addressInfo.CreationTransactionHash)
+	blockNumber, err := getTransactionBlockNumber(client, blockscoutURL, addressInfo.CreationTransactionHash)
 	if err != nil {
-		return 0, "", fmt.Errorf("failed to get transaction block: %v", err)
+		return 0, "", fmt.Errorf("failed to get transaction block: %w", err)
 	}
 
 	return blockNumber, addressInfo.CreationTransactionHash, nil
 }
 
 // getTransactionBlockNumber gets the block number for a transaction hash using Blockscout v2 API
-func getTransactionBlockNumber(blockscoutURL, txHash string) (uint64, error) {
+func getTransactionBlockNumber(client *APIClient, blockscoutURL, txHash string) (uint64, error) {
 	url := fmt.Sprintf("%s/api/v2/transactions/%s", blockscoutURL, txHash)
 
-	resp, err := http.Get(url)
-	if err != nil {
-		return 0, fmt.Errorf("failed to fetch transaction info: %v", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != 200 {
-		return 0, fmt.Errorf("API returned status %d", resp.StatusCode)
-	}
-
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return 0, fmt.Errorf("failed to read response: %v", err)
-	}
-
 	// Parse transaction response
 	var txInfo struct {
 		BlockNumber int64 `json:"block_number"`
 	}
 
-	if err := json.Unmarshal(body, &txInfo); err != nil {
-		return 0, fmt.Errorf("failed to parse transaction response: %v", err)
+	if err := client.GetJSON(url, &txInfo); err != nil {
+		return 0, fmt.Errorf("failed to fetch transaction info: %w", err)
 	}
 
 	if txInfo.BlockNumber <= 0 {
@@ -236,126 +244,420 @@ func getTransactionBlockNumber(blockscoutURL, txHash string) (uint64, error) {
 	return uint64(txInfo.BlockNumber), nil
 }
 
-// processAddressCreationBlocks processes each address individually to get creation blocks
-func processAddressCreationBlocks(blockscoutURL string, targetAddresses []string) map[string]ContractInfo {
+// processAddressCreationBlocks processes each address individually to get
+// creation blocks. Every address is returned with its classification.
+func processAddressCreationBlocks(source ChainSource, targetAddresses []string) map[string]ContractInfo {
 	addressInfo := make(map[string]ContractInfo)
 
-	logInfo("Processing %d addresses for creation blocks...", len(targetAddresses))
+	logger.Info("Processing addresses for creation blocks", "addresses", len(targetAddresses))
 
 	// Progress tracking
 	totalAddresses := len(targetAddresses)
 	validContracts := 0
 	skippedContracts := 0
 
+	// Backends with a batch endpoint resolve several addresses per call
+	batcher, batched := source.(BatchCreationSource)
+	var batch map[string]ContractCreationResult
+	var batchErr error
+
 	for i, address := range targetAddresses {
 		// Show progress every 10 addresses or at key milestones (always shown regardless of log level)
 		if i%10 == 0 || i == totalAddresses-1 {
 			progress := float64(i+1) / float64(totalAddresses) * 100
-			fmt.Printf("📊 Progress: %d/%d (%.1f%%) | Valid: %d | Skipped: %d\n",
+			progressf("📊 Progress: %d/%d (%.1f%%) | Valid: %d | Skipped: %d\n",
 				i+1, totalAddresses, progress, validContracts, skippedContracts)
 		}
-		logDebug("Processing address %d/%d: %s", i+1, len(targetAddresses), address)
-
-		creationBlock, creationTx, err := getContractCreationBlock(blockscoutURL, address)
+		logger.Debug("Processing address", "address", address, "index", i+1, "total", len(targetAddresses))
+
+		var creationBlock uint64
+		var creationTx string
+		var err error
+		if batched {
+			batchSize := batcher.CreationBatchSize()
+			if i%batchSize == 0 {
+				end := i + batchSize
+				if end > totalAddresses {
+					end = totalAddresses
+				}
+				batch, batchErr = batcher.ContractCreations(targetAddresses[i:end])
+			}
+			creationBlock, creationTx, err = lookupBatchedCreation(batch, batchErr, address)
+		} else {
+			creationBlock, creationTx, err = source.ContractCreation(address)
+		}
 		if err != nil {
 			skippedContracts++
+			info := ContractInfo{Address: address, Status: classifyCreationError(err)}
 
 			// Always log skipped addresses (minimal info)
-			if strings.Contains(err.Error(), "is_contract: false") {
-				fmt.Printf("⏭️  SKIP %s: Not a contract\n", address)
-				logDebug("SKIPPED %s: Not a smart contract (is_contract: false)", address)
-			} else if strings.Contains(err.Error(), "no implementations found") {
-				fmt.Printf("⏭️  SKIP %s: Not a proxy\n", address)
-				logDebug("SKIPPED %s: Not a proxy contract (no implementations found)", address)
-			} else if strings.Contains(err.Error(), "no creation transaction") {
-				fmt.Printf("⏭️  SKIP %s: No creation tx\n", address)
-				logDebug("SKIPPED %s: No creation transaction found", address)
-			} else if strings.Contains(err.Error(), "API returned status") {
-				fmt.Printf("❌ SKIP %s: API error\n", address)
-				logError("SKIPPED %s: API error - %v", address, err)
-			} else {
-				fmt.Printf("❌ SKIP %s: Error\n", address)
-				logError("SKIPPED %s: Failed to get creation info - %v", address, err)
+			switch info.Status {
+			case StatusNotContract:
+				progressf("⏭️  SKIP %s: Not a contract\n", address)
+				logger.Debug("Skipped address: not a smart contract", "address", address)
+			case StatusNotProxy:
+				progressf("⏭️  SKIP %s: Not a proxy\n", address)
+				logger.Debug("Skipped address: not a proxy contract (no implementations found)", "address", address)
+			case StatusNoCreationTx:
+				progressf("⏭️  SKIP %s: No creation tx\n", address)
+				logger.Debug("Skipped address: no creation transaction found", "address", address)
+			default:
+				// Looked up again when the scan is resumed
+				info.StatusError = err.Error()
+				progressf("❌ SKIP %s: %s\n", address, apiErrorKind(err))
+				logger.Warn("Skipped address: failed to get creation info", "address", address, "err", err)
 			}
-			// Don't include filtered addresses in the addressInfo map
+			addressInfo[address] = info
 			continue
-		} else {
-			validContracts++
-
-			// Always log valid addresses (minimal info)
-			fmt.Printf("✅ VALID %s: Block %d\n", address, creationBlock)
-			logInfo("VALID PROXY CONTRACT %s: created in block %d (tx: %s)", address, creationBlock, creationTx)
-			addressInfo[address] = ContractInfo{
-				Address:       address,
-				CreationBlock: creationBlock,
-				CreationTx:    creationTx,
-				Processed:     false,
-			}
 		}
 
-		// Add delay to avoid rate limiting
-		time.Sleep(200 * time.Millisecond)
+		validContracts++
+
+		// Always log valid addresses (minimal info)
+		progressf("✅ VALID %s: Block %d\n", address, creationBlock)
+		logger.Info("Valid proxy contract", "address", address, "creation_block", creationBlock, "tx", creationTx)
+		addressInfo[address] = ContractInfo{
+			Address:       address,
+			CreationBlock: creationBlock,
+			CreationTx:    creationTx,
+			Processed:     false,
+			Status:        StatusValid,
+		}
 	}
 
-	logInfo("SUMMARY: Found %d valid proxy contracts out of %d addresses processed", len(addressInfo), len(targetAddresses))
+	logger.Info("Processed addresses for creation blocks", "valid", validContracts, "addresses", len(targetAddresses))
 	return addressInfo
 }
 
-// loadAddressProgress loads progress for address-based scanning
-func loadAddressProgress(progressFile string) AddressProgress {
-	file, err := os.Open(progressFile)
-	if err != nil {
-		return AddressProgress{
-			Addresses: make(map[string]ContractInfo),
+// lookupBatchedCreation returns an address's entry from a batch of
+// ContractCreations results
+func lookupBatchedCreation(batch map[string]ContractCreationResult, batchErr error, address string) (uint64, string, error) {
+	if batchErr != nil {
+		return 0, "", fmt.Errorf("failed to fetch contract creations: %w", batchErr)
+	}
+	creation, ok := batch[strings.ToLower(address)]
+	if !ok {
+		return 0, "", ErrNotContract
+	}
+	if creation.Err != nil {
+		return 0, "", creation.Err
+	}
+	if creation.TxHash == "" {
+		return 0, "", ErrNoCreationTx
+	}
+	return creation.Block, creation.TxHash, nil
+}
+
+// progressSchemaVersion is the layout of the progress file. Files written
+// before it was recorded are version 0; migrateProgress upgrades older files
+// when they are loaded.
+const progressSchemaVersion = 1
+
+// progressBackupName returns where the previous checkpoint of a progress
+// file is kept
+func progressBackupName(progressFile string) string {
+	return progressFile + ".bak"
+}
+
+// loadAddressProgress loads progress for address-based scanning. A missing
+// file means a fresh scan. A file that can't be read falls back to the backup
+// of the previous checkpoint, and if that is unusable too loading fails
+// rather than silently starting the scan over.
+func loadAddressProgress(progressFile string) (AddressProgress, error) {
+	progress, err := readProgressFile(progressFile)
+	if err == nil {
+		return progress, nil
+	}
+	// The backup of a newer file is no more usable
+	var versionErr *ProgressVersionError
+	if errors.As(err, &versionErr) {
+		return AddressProgress{}, err
+	}
+
+	backupFile := progressBackupName(progressFile)
+	backup, backupErr := readProgressFile(backupFile)
+	switch {
+	case backupErr == nil:
+		if os.IsNotExist(err) {
+			logger.Warn("Progress file is missing, resuming from backup", "file", progressFile, "backup", backupFile)
+		} else {
+			logger.Warn("Progress file is unreadable, resuming from backup; the last chunk will be rescanned",
+				"file", progressFile, "backup", backupFile, "err", err)
 		}
+		return backup, nil
+	case os.IsNotExist(err) && os.IsNotExist(backupErr):
+		return AddressProgress{Addresses: make(map[string]ContractInfo)}, nil
+	case os.IsNotExist(backupErr):
+		return AddressProgress{}, fmt.Errorf("progress file %s is unreadable and has no backup: %v (move it aside to start the scan over)", progressFile, err)
+	default:
+		return AddressProgress{}, fmt.Errorf("progress file %s and its backup are unreadable: %v; backup: %v (move them aside to start the scan over)", progressFile, err, backupErr)
 	}
-	defer file.Close()
+}
+
+// ProgressVersionError reports a progress file written by a newer version of
+// the scanner
+type ProgressVersionError struct {
+	Path    string
+	Version int
+}
 
+func (e *ProgressVersionError) Error() string {
+	return fmt.Sprintf("%s has progress schema version %d, newer than supported version %d", e.Path, e.Version, progressSchemaVersion)
+}
+
+// readProgressFile decodes and migrates a progress file. Errors satisfy
+// os.IsNotExist when the file doesn't exist.
+func readProgressFile(path string) (AddressProgress, error) {
 	var progress AddressProgress
-	if err := json.NewDecoder(file).Decode(&progress); err != nil {
-		logError("Warning: Could not decode progress file: %v", err)
-		return AddressProgress{
-			Addresses: make(map[string]ContractInfo),
-		}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return progress, err
+	}
+	if err := json.Unmarshal(data, &progress); err != nil {
+		return progress, fmt.Errorf("corrupt progress file: %v", err)
+	}
+	if progress.SchemaVersion > progressSchemaVersion {
+		return progress, &ProgressVersionError{Path: path, Version: progress.SchemaVersion}
+	}
+	if progress.ScanID == "" {
+		return progress, fmt.Errorf("corrupt progress file: no scan_id")
 	}
+	migrateProgress(&progress)
+	return progress, nil
+}
 
-	return progress
+// migrateProgress upgrades progress loaded from an older file to
+// progressSchemaVersion
+func migrateProgress(progress *AddressProgress) {
+	if progress.SchemaVersion < 1 {
+		// Unversioned files predate output formats and may lack the maps
+		if progress.OutputFormat == "" {
+			progress.OutputFormat = OutputCSV
+		}
+		if progress.Addresses == nil {
+			progress.Addresses = make(map[string]ContractInfo)
+		}
+		if progress.RuleFindings == nil {
+			progress.RuleFindings = make(map[string]int)
+		}
+	}
+	progress.SchemaVersion = progressSchemaVersion
 }
 
-// saveAddressProgress saves progress for address-based scanning
-func saveAddressProgress(progressFile string, progress AddressProgress) {
+// saveAddressProgress checkpoints progress for address-based scanning. The
+// file is replaced atomically and the previous checkpoint kept as a backup.
+func saveAddressProgress(progressFile string, progress AddressProgress) error {
+	progress.SchemaVersion = progressSchemaVersion
 	progress.LastUpdated = time.Now()
 
-	file, err := os.Create(progressFile)
+	data, err := json.Marshal(progress)
 	if err != nil {
-		logError("Warning: Could not save progress: %v", err)
+		return fmt.Errorf("failed to encode progress: %v", err)
+	}
+	if err := writeFileAtomic(progressFile, append(data, '\n'), progressBackupName(progressFile)); err != nil {
+		return fmt.Errorf("failed to save progress to %s: %v", progressFile, err)
+	}
+	return nil
+}
+
+// removeProgressFile deletes a progress file and its backup
+func removeProgressFile(progressFile string) error {
+	if err := os.Remove(progressBackupName(progressFile)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return os.Remove(progressFile)
+}
+
+// scanComplete reports whether a scan has nothing left to do: every
+// scannable address has been swept without coverage gaps and no address is
+// waiting to be looked up again
+func scanComplete(progress AddressProgress) bool {
+	for _, info := range progress.Addresses {
+		if info.classification() == StatusAPIError || (info.Scannable() && !info.Processed) {
+			return false
+		}
+	}
+	return true
+}
+
+// completeScan marks a finished scan complete in the store, where its
+// address records are kept. Without a store its progress file is removed, or
+// kept as the completed progress file while it has skipped addresses.
+func completeScan(progress AddressProgress, progressFile string, store *Store) {
+	if store != nil {
+		if err := store.CompleteScan(progress.ScanID); err != nil {
+			logger.Error("Failed to mark scan complete in the store", "err", err)
+			return
+		}
+		progressf("Scan %s marked complete in %s\n", progress.ScanID, store.path)
+		return
+	}
+
+	if skipped := len(progress.Addresses) - countScannable(progress); skipped > 0 {
+		completedFile := getCompletedProgressFileName(progress.ScanID)
+		if err := os.Rename(progressFile, completedFile); err != nil {
+			logger.Error("Failed to keep completed scan", "file", completedFile, "err", err)
+			return
+		}
+		if err := os.Remove(progressBackupName(progressFile)); err != nil && !os.IsNotExist(err) {
+			logger.Error("Failed to remove progress file", "file", progressBackupName(progressFile), "err", err)
+		}
+		progressf("Progress file moved to %s (scan completed; its %d skipped address(es) can be reclassified)\n", completedFile, skipped)
 		return
 	}
-	defer file.Close()
 
-	if err := json.NewEncoder(file).Encode(progress); err != nil {
-		logError("Warning: Could not encode progress: %v", err)
+	// Clean up progress file on successful completion
+	if err := removeProgressFile(progressFile); err != nil {
+		logger.Error("Failed to remove progress file", "file", progressFile, "err", err)
 	}
+	progressf("Progress file %s removed (scan completed)\n", progressFile)
 }
 
-// getBlockTransactions fetches all transactions in a block
-func getBlockTransactions(blockscoutURL string, blockNumber uint64) ([]string, error) {
-	url := fmt.Sprintf("%s/api/v2/blocks/%d/transactions", blockscoutURL, blockNumber)
+// newAddresses returns the target addresses a scan doesn't know yet, in
+// input order and without duplicates
+func newAddresses(progress AddressProgress, targetAddresses []string) []string {
+	seen := make(map[string]bool)
+	var added []string
+	for _, address := range targetAddresses {
+		if _, known := progress.Addresses[address]; known || seen[address] {
+			continue
+		}
+		seen[address] = true
+		added = append(added, address)
+	}
+	return added
+}
+
+// predecessorScan returns the unfinished scan, if any, that the configured
+// scan continues: one with the same configuration over a subset of its
+// target addresses, started before the others were added to the input.
+// The largest such scan is returned, or an empty progress if there is none.
+func predecessorScan(config ScannerConfig, store *Store) AddressProgress {
+	targets := make(map[string]bool, len(config.TargetAddresses))
+	for _, address := range config.TargetAddresses {
+		targets[address] = true
+	}
+
+	var ids []string
+	if store != nil {
+		active, err := store.ActiveScans()
+		if err != nil {
+			logger.Error("Failed to list scans", "store", store.path, "err", err)
+		}
+		ids = active
+	} else {
+		ids = scanFileIDs("scan_progress_")
+	}
 
-	resp, err := http.Get(url)
+	var best AddressProgress
+	for _, id := range ids {
+		progress, err := loadScanProgress(store, id)
+		if err != nil || len(progress.Addresses) >= len(targets) || len(progress.Addresses) <= len(best.Addresses) {
+			continue
+		}
+		addresses := make([]string, 0, len(progress.Addresses))
+		for address := range progress.Addresses {
+			if !targets[address] {
+				break
+			}
+			addresses = append(addresses, address)
+		}
+		if len(addresses) != len(progress.Addresses) {
+			continue
+		}
+
+		// Same ID over its addresses means the rest of the configuration matches
+		previous := config
+		previous.TargetAddresses = addresses
+		if generateScanID(previous) == id {
+			best = progress
+		}
+	}
+	return best
+}
+
+// takeOverScan moves a predecessor scan's progress to scanID: it is saved
+// under the new ID, and the predecessor is marked complete in the store or
+// its progress file removed
+func takeOverScan(previous AddressProgress, scanID, progressFile string, store *Store) AddressProgress {
+	previousID := previous.ScanID
+	progress := previous
+	progress.ScanID = scanID
+
+	if store != nil {
+		if err := store.StartScan(progress); err != nil {
+			fatal("Failed to record scan in store", "err", err)
+		}
+		if err := store.CompleteScan(previousID); err != nil {
+			logger.Error("Failed to mark scan complete in the store", "scan_id", previousID, "err", err)
+		}
+		return progress
+	}
+
+	if err := saveAddressProgress(progressFile, progress); err != nil {
+		fatal("Failed to save progress", "err", err)
+	}
+	if err := removeProgressFile(getProgressFileName(previousID)); err != nil {
+		logger.Error("Failed to remove progress file", "file", getProgressFileName(previousID), "err", err)
+	}
+	return progress
+}
+
+// scanFileIDs returns the IDs of the scans with a file named
+// <prefix><id>.json in the working directory, e.g. "scan_progress_"
+func scanFileIDs(prefix string) []string {
+	files, err := filepath.Glob(prefix + "*.json")
 	if err != nil {
-		return nil, fmt.Errorf("failed to fetch block transactions: %v", err)
+		return nil
+	}
+	ids := make([]string, 0, len(files))
+	for _, file := range files {
+		ids = append(ids, strings.TrimSuffix(strings.TrimPrefix(filepath.Base(file), prefix), ".json"))
 	}
-	defer resp.Body.Close()
+	return ids
+}
+
+// loadCompletedScan loads the progress a completed scan was left with, from
+// the store or its completed progress file. The returned progress has an
+// empty ScanID if there is none.
+func loadCompletedScan(store *Store, scanID string) (AddressProgress, error) {
+	if store != nil {
+		return store.LoadCompletedScan(scanID)
+	}
+	return loadAddressProgress(getCompletedProgressFileName(scanID))
+}
 
-	if resp.StatusCode != 200 {
-		return nil, fmt.Errorf("API returned status %d", resp.StatusCode)
+// loadScanProgress loads a scan's progress from the store when one is open,
+// falling back to its progress file so that a scan started without a store
+// can be resumed into one
+func loadScanProgress(store *Store, scanID string) (AddressProgress, error) {
+	progressFile := getProgressFileName(scanID)
+	if store == nil {
+		return loadAddressProgress(progressFile)
+	}
+
+	progress, err := store.LoadProgress(scanID)
+	if err != nil || progress.ScanID != "" {
+		return progress, err
 	}
 
-	body, err := io.ReadAll(resp.Body)
+	progress, err = loadAddressProgress(progressFile)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read response: %v", err)
+		return progress, err
 	}
+	if progress.ScanID != "" {
+		logger.Info("Importing scan progress into the store", "scan_id", scanID, "file", progressFile)
+		if err := store.StartScan(progress); err != nil {
+			return progress, err
+		}
+	}
+	return progress, nil
+}
+
+// getBlockTransactions fetches all transactions in a block
+func getBlockTransactions(client *APIClient, blockscoutURL string, blockNumber uint64) ([]string, error) {
+	url := fmt.Sprintf("%s/api/v2/blocks/%d/transactions", blockscoutURL, blockNumber)
 
 	// Parse transactions response
 	var txResponse struct {
@@ -364,8 +666,8 @@ func getBlockTransactions(blockscoutURL string, blockNumber uint64) ([]string, e
 		} `json:"items"`
 	}
 
-	if err := json.Unmarshal(body, &txResponse); err != nil {
-		return nil, fmt.Errorf("failed to parse transactions response: %v", err)
+	if err := client.GetJSON(url, &txResponse); err != nil {
+		return nil, fmt.Errorf("failed to fetch block transactions: %w", err)
 	}
 
 	var txHashes []string
@@ -377,55 +679,29 @@ func getBlockTransactions(blockscoutURL string, blockNumber uint64) ([]string, e
 }
 
 // getTransactionLogs fetches all logs/events for a specific transaction
-func getTransactionLogs(blockscoutURL string, txHash string) ([]map[string]interface{}, error) {
+func getTransactionLogs(client *APIClient, blockscoutURL string, txHash string) ([]map[string]interface{}, error) {
 	url := fmt.Sprintf("%s/api/v2/transactions/%s/logs", blockscoutURL, txHash)
 
-	resp, err := http.Get(url)
-	if err != nil {
-		return nil, fmt.Errorf("failed to fetch transaction logs: %v", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != 200 {
-		return nil, fmt.Errorf("API returned status %d", resp.StatusCode)
-	}
-
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read response: %v", err)
-	}
-
 	// Parse logs response
 	var logsResponse struct {
 		Items []map[string]interface{} `json:"items"`
 	}
 
-	if err := json.Unmarshal(body, &logsResponse); err != nil {
-		return nil, fmt.Errorf("failed to parse logs response: %v", err)
+	if err := client.GetJSON(url, &logsResponse); err != nil {
+		return nil, fmt.Errorf("failed to fetch transaction logs: %w", err)
 	}
 
 	return logsResponse.Items, nil
 }
 
 func main() {
-	// Set log level from environment variable
-	if level := os.Getenv("LOG_LEVEL"); level != "" {
-		switch strings.ToUpper(level) {
-		case "ERROR":
-			logLevel = LOG_ERROR
-		case "INFO":
-			logLevel = LOG_INFO
-		case "DEBUG":
-			logLevel = LOG_DEBUG
-		default:
-			logLevel = LOG_INFO
-		}
-	}
-
-	logInfo("Starting CPIMP Scanner with log level: %d", logLevel)
+	os.Exit(runCLI(os.Args[1:]))
+}
 
-	// Load configuration
-	config := DefaultConfig()
+// runScan runs a complete scan for the given configuration, resuming from
+// its progress file if one exists
+func runScan(config ScannerConfig) {
+	logger.Info("Starting CPIMP Scanner", "log_levels", describeLogLevels(), "log_format", logSettings.format)
 
 	// Generate unique scan ID
 	scanID := generateScanID(config)
@@ -434,35 +710,96 @@ func main() {
 	// Get network configuration
 	network, exists := Networks[config.Network]
 	if !exists {
-		log.Fatalf("Unknown network: %s", config.Network)
+		fatal("Unknown network", "network", config.Network)
 	}
 
-	fmt.Printf("Starting blockchain scan for Upgraded events on %s...\n", network.Name)
-	fmt.Printf("Scan ID: %s\n", scanID)
+	// A scan-level RPC URL overrides the network's
+	network.RPCURL = rpcURL(config, network)
+
+	// Every API call of the scan, from any worker, shares the per-host limit
+	client := NewAPIClient(config.RateLimit)
+
+	if config.MetricsAddr != "" {
+		if err := startMetricsServer(config.MetricsAddr); err != nil {
+			fatal("Failed to start metrics server", "err", err)
+		}
+	}
+
+	// Make sure the endpoint serves the chain we think it does
+	if err := verifyChainID(client, network); errors.Is(err, errNoRPCEndpoint) {
+		logger.Info("Skipping chain ID check, network has no RPC endpoint", "network", config.Network)
+	} else if err != nil {
+		var mismatch *ChainIDMismatchError
+		if errors.As(err, &mismatch) {
+			fatal("Wrong chain", "err", err)
+		}
+		logger.Warn("Could not verify chain ID", "network", config.Network, "err", err)
+	}
+
+	source, err := newChainSource(config, network, client)
+	if err != nil {
+		fatal("Failed to set up chain data backend", "err", err)
+	}
+
+	progressf("Starting blockchain scan for Upgraded events on %s (backend: %s)...\n", network.Name, source.Name())
+	progressf("Scan ID: %s\n", scanID)
 
 	// Get the latest block number
-	latestBlock, err := getLatestBlockNumber(network.BlockscoutURL)
+	latestBlock, err := source.LatestBlockNumber()
 	if err != nil {
-		log.Fatalf("Failed to get latest block number: %v", err)
+		fatal("Failed to get latest block number", "err", err)
+	}
+	metricChainHead.Set(float64(latestBlock))
+
+	var store *Store
+	if config.Store != "" {
+		store, err = OpenStore(config.Store)
+		if err != nil {
+			fatal("Failed to open store", "err", err)
+		}
+		defer store.Close()
 	}
 
+	setLogScan(scanID, config.Network)
+
 	// Load address-based progress
-	addressProgress := loadAddressProgress(progressFile)
+	addressProgress, err := loadScanProgress(store, scanID)
+	if err != nil {
+		fatal("Failed to load scan progress", "err", err)
+	}
+
+	// Addresses added to the input give the scan a new ID; it takes over the
+	// scan of the addresses it had before, and the added ones are found by
+	// diffing below
+	if addressProgress.ScanID == "" && len(config.TargetAddresses) > 0 {
+		if previous := predecessorScan(config, store); previous.ScanID != "" {
+			progressf("Continuing scan %s with the addresses added to the input\n", previous.ScanID)
+			addressProgress = takeOverScan(previous, scanID, progressFile, store)
+		}
+	}
 
 	// Initialize or update address progress
 	if addressProgress.ScanID == "" {
 		// Fresh scan - process addresses to get creation blocks
-		addressInfo := processAddressCreationBlocks(network.BlockscoutURL, config.TargetAddresses)
+		addressInfo := processAddressCreationBlocks(source, config.TargetAddresses)
 
 		addressProgress = AddressProgress{
 			Addresses:   addressInfo,
 			ScanID:      scanID,
 			Network:     config.Network,
+			Backend:     source.Name(),
 			EventTopic:  config.EventTopic,
+			Rules:       config.Rules,
 			LastUpdated: time.Now(),
 		}
 
-		saveAddressProgress(progressFile, addressProgress)
+		if store != nil {
+			if err := store.StartScan(addressProgress); err != nil {
+				fatal("Failed to record scan in store", "err", err)
+			}
+		} else if err := saveAddressProgress(progressFile, addressProgress); err != nil {
+			fatal("Failed to save progress", "err", err)
+		}
 
 		// Determine the earliest creation block for overall scan range
 		var earliestBlock uint64 = ^uint64(0) // Max uint64
@@ -484,329 +821,230 @@ func main() {
 
 		endBlock := config.EndBlock
 		if endBlock == 0 {
-			endBlock = latestBlock
+			endBlock = confirmedHead(latestBlock, config.Confirmations)
 		}
 
-		fmt.Printf("Starting fresh address-based scan from block %d to %d (latest: %d)\n", startBlock, endBlock, latestBlock)
-		fmt.Printf("Targeting %d addresses (%d with known creation blocks)\n", len(config.TargetAddresses), validContracts)
+		progressf("Starting fresh address-based scan from block %d to %d (latest: %d)\n", startBlock, endBlock, latestBlock)
+		progressf("Targeting %d addresses (%d with known creation blocks)\n", len(config.TargetAddresses), validContracts)
 	} else {
-		fmt.Printf("Resuming address-based scan\n")
-		fmt.Printf("📋 Address Summary: %d total loaded, %d valid proxy contracts found\n",
-			len(config.TargetAddresses), len(addressProgress.Addresses))
-		fmt.Printf("   (Only proxy contracts with implementations are scanned for Upgraded events)\n")
+		progressf("Resuming address-based scan\n")
+
+		// Addresses whose lookup failed are classified again, and addresses
+		// added to the input since the scan started are classified and
+		// enqueued
+		retry := addressesWithStatus(addressProgress, StatusAPIError)
+		if len(retry) > 0 {
+			progressf("🔁 Re-checking %d address(es) whose creation lookup failed\n", len(retry))
+		}
+		if added := newAddresses(addressProgress, config.TargetAddresses); len(added) > 0 {
+			progressf("➕ %d address(es) added to the input since the scan started\n", len(added))
+			retry = append(retry, added...)
+		}
+		if len(retry) > 0 {
+			for address, info := range processAddressCreationBlocks(source, retry) {
+				addressProgress.Addresses[address] = info
+			}
+			if store != nil {
+				err = store.SaveProgress(addressProgress, retry...)
+			} else {
+				err = saveAddressProgress(progressFile, addressProgress)
+			}
+			if err != nil {
+				fatal("Failed to save address classifications", "err", err)
+			}
+		}
 
-		if logLevel >= LOG_INFO {
-			fmt.Printf("Previous progress: %d logs found, %d duplicate transactions\n", addressProgress.TotalLogs, addressProgress.DuplicateTxs)
+		progressf("📋 Address Summary: %d total loaded, %d valid proxy contracts found\n",
+			len(config.TargetAddresses), countScannable(addressProgress))
+		progressf("   (Only proxy contracts with implementations are scanned for Upgraded events)\n")
+
+		if logEnabled(logger, slog.LevelInfo) {
+			progressf("Previous progress: %d logs found, %d duplicate transactions\n", addressProgress.TotalLogs, addressProgress.DuplicateTxs)
 		}
 
 		// Show address status
 		processed := 0
 		for _, info := range addressProgress.Addresses {
-			if info.Processed {
+			if info.Scannable() && info.Processed {
 				processed++
 			}
 		}
-		fmt.Printf("Address progress: %d/%d addresses completed\n", processed, len(addressProgress.Addresses))
+		progressf("Address progress: %d/%d addresses completed\n", processed, countScannable(addressProgress))
 	}
 
-	fmt.Printf("Progress file: %s\n\n", progressFile)
+	if store != nil {
+		progressf("Progress store: %s\n\n", config.Store)
+	} else {
+		progressf("Progress file: %s\n\n", progressFile)
+	}
 
-	// Prepare CSV file
-	file, err := os.OpenFile(config.OutputFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	// Prepare output file
+	writer, err := openFindingsWriter(config.OutputFile, config.OutputFormat)
 	if err != nil {
-		log.Fatalf("Failed to open CSV file: %v", err)
+		fatal("Failed to open output file", "file", config.OutputFile, "err", err)
+	}
+	defer writer.Close()
+	if config.OutputFormat == OutputJSON && (config.Watch || len(config.TargetAddresses) == 0) {
+		logger.Warn("json output is rewritten whole on every write; use -format ndjson for long scans", "file", config.OutputFile)
 	}
-	defer file.Close()
 
-	writer := csv.NewWriter(file)
-	defer writer.Flush()
+	addressProgress.OutputFile = config.OutputFile
+	addressProgress.OutputFormat = config.OutputFormat
+	session := newScanSession(config, network, client, source, &addressProgress, progressFile, writer, store)
 
-	// Write CSV header only if file is empty
-	fileInfo, _ := file.Stat()
-	if fileInfo.Size() == 0 {
-		writer.Write([]string{"Transaction Hash", "Explorer Link", "From Address", "Block Number"})
+	session.notifier, err = openNotifier(config.Alerts)
+	if err != nil {
+		fatal("Failed to set up alerts", "err", err)
+	}
+	defer session.notifier.Close()
+	if len(config.Alerts.Sinks) > 0 {
+		progressf("📣 Alerting %d sink(s) of new findings\n", len(config.Alerts.Sinks))
 	}
 
 	// Track performance metrics
 	startTime := time.Now()
-	var totalAPITime time.Duration
-	requestCount := 0
 
-	// Progress tracking for address scanning
-	totalAddressesToScan := len(addressProgress.Addresses)
-	completedAddresses := 0
-	for _, info := range addressProgress.Addresses {
-		if info.Processed {
-			completedAddresses++
+	// Scan addresses in a stable order; completed ones are skipped up front
+	addresses := make([]string, 0, len(addressProgress.Addresses))
+	for address, info := range addressProgress.Addresses {
+		if info.Scannable() {
+			addresses = append(addresses, address)
 		}
 	}
-
-	fmt.Printf("\n🚀 Starting scan: %d addresses total, %d already completed\n", totalAddressesToScan, completedAddresses)
-
-	// Scan each address individually from its creation block
-	addressIndex := 0
-	for address, info := range addressProgress.Addresses {
-		addressIndex++
-
-		if info.Processed {
-			fmt.Printf("⏭️  Skipping already processed address %d/%d: %s\n", addressIndex, totalAddressesToScan, address)
+	sort.Strings(addresses)
+
+	session.totalAddresses = len(addresses)
+	var pending []int
+	for i, address := range addresses {
+		if addressProgress.Addresses[address].Processed {
+			session.completedAddresses++
+			progressf("⏭️  Skipping already processed address %d/%d: %s\n", i+1, len(addresses), address)
 			continue
 		}
+		pending = append(pending, i)
+	}
+	registerScanMetrics(session)
 
-		// Show progress (always visible regardless of log level)
-		remainingAddresses := totalAddressesToScan - completedAddresses
-		fmt.Printf("\n📍 Scanning address %d/%d (%d remaining): %s\n",
-			addressIndex, totalAddressesToScan, remainingAddresses, address)
-		if info.CreationBlock > 0 {
-			logInfo("Starting from creation block: %d", info.CreationBlock)
-		} else {
-			logInfo("Creation block unknown, starting from block 0")
-		}
-
-		startBlock := info.CreationBlock
-		if startBlock == 0 {
-			startBlock = config.StartBlock
-		}
-
-		endBlock := config.EndBlock
-		if endBlock == 0 {
-			endBlock = latestBlock
-		}
-
-		// Scan this address in chunks
-		addressLogs := 0
-		addressDuplicates := 0
-
-		for fromBlock := startBlock; fromBlock <= endBlock; fromBlock += config.BlockRange {
-			toBlock := fromBlock + config.BlockRange - 1
-			if toBlock > endBlock {
-				toBlock = endBlock
-			}
-
-			logDebug("Scanning blocks %d to %d for %s...", fromBlock, toBlock, address)
-
-			// Log all transactions in each block in this range (DEBUG level only)
-			if logLevel >= LOG_DEBUG {
-				fmt.Printf("\n  Block transaction details:\n")
-				for blockNum := fromBlock; blockNum <= toBlock; blockNum++ {
-					txHashes, err := getBlockTransactions(network.BlockscoutURL, blockNum)
-					if err != nil {
-						fmt.Printf("    Block %d: Error fetching transactions - %v\n", blockNum, err)
-						continue
-					}
-
-					if len(txHashes) == 0 {
-						fmt.Printf("    Block %d: No transactions\n", blockNum)
-					} else {
-						fmt.Printf("    Block %d: %d transactions\n", blockNum, len(txHashes))
-						for i, txHash := range txHashes {
-							fmt.Printf("      TX %d: %s\n", i+1, txHash)
-
-							// Fetch and display logs for this transaction
-							logs, err := getTransactionLogs(network.BlockscoutURL, txHash)
-							if err != nil {
-								fmt.Printf("        Error fetching logs: %v\n", err)
-								continue
-							}
-
-							if len(logs) == 0 {
-								fmt.Printf("        No logs/events\n")
-							} else {
-								fmt.Printf("        %d logs/events:\n", len(logs))
-								for j, logEntry := range logs {
-									// Extract key fields from the log
-									address := ""
-									topics := []interface{}{}
-									data := ""
-									decoded := map[string]interface{}{}
-
-									if addr, ok := logEntry["address"].(map[string]interface{}); ok {
-										if hash, exists := addr["hash"].(string); exists {
-											address = hash
-										}
-									}
-									if topicsArray, ok := logEntry["topics"].([]interface{}); ok {
-										topics = topicsArray
-									}
-									if dataStr, ok := logEntry["data"].(string); ok {
-										data = dataStr
-									}
-									if decodedData, ok := logEntry["decoded"].(map[string]interface{}); ok {
-										decoded = decodedData
-									}
-
-									fmt.Printf("          Log %d: address=%s\n", j+1, address)
-
-									// Show decoded event information if available
-									if methodCall, exists := decoded["method_call"].(string); exists {
-										fmt.Printf("                 event=%s\n", methodCall)
-										if params, exists := decoded["parameters"].([]interface{}); exists {
-											fmt.Printf("                 parameters:\n")
-											for k, param := range params {
-												if paramMap, ok := param.(map[string]interface{}); ok {
-													name := paramMap["name"]
-													value := paramMap["value"]
-													paramType := paramMap["type"]
-													indexed := paramMap["indexed"]
-													fmt.Printf("                   %d. %s (%s, indexed:%v) = %v\n", k+1, name, paramType, indexed, value)
-												}
-											}
-										}
-									} else {
-										// Fallback to raw topic display
-										fmt.Printf("                 topics=%v\n", topics)
-									}
-
-									if len(data) > 100 {
-										fmt.Printf("                 data=%s... (%d chars)\n", data[:100], len(data))
-									} else if data != "" && data != "0x" {
-										fmt.Printf("                 data=%s\n", data)
-									}
-								}
-							}
-						}
-					}
-				}
-			} // End of DEBUG level transaction logging
-
-			// Measure API call time
-			apiStart := time.Now()
-			logs, err := fetchLogs(network.BlockscoutURL, config.EventTopic, fromBlock, toBlock, []string{address})
-			apiDuration := time.Since(apiStart)
-			totalAPITime += apiDuration
-			requestCount++
-
-			if err != nil {
-				logError("Error fetching logs for blocks %d-%d: %v", fromBlock, toBlock, err)
-				continue
-			}
+	// Blocks within the confirmation depth of the head are left for later
+	endBlock := config.EndBlock
+	if endBlock == 0 {
+		endBlock = confirmedHead(latestBlock, config.Confirmations)
+	}
 
-			// Group logs by transaction hash for this chunk
-			chunkLogs := make(map[string][]LogEntry)
-			for _, logEntry := range logs {
-				chunkLogs[logEntry.TransactionHash] = append(chunkLogs[logEntry.TransactionHash], logEntry)
-			}
+	// Undo results from blocks reorged out since the previous run
+	session.startReorgTracking(endBlock, latestBlock)
+	_, reorged := session.checkReorgs(endBlock)
 
-			addressProgress.TotalLogs += len(logs)
-			addressLogs += len(logs)
+	workers := workerCount(config.Concurrency, len(pending))
+	progressf("\n🚀 Starting scan: %d addresses total, %d already completed (%d workers)\n",
+		session.totalAddresses, session.completedAddresses, workers)
 
-			// Log details about found events (DEBUG level only)
-			if len(logs) > 0 && logLevel >= LOG_DEBUG {
-				fmt.Printf("\n  Found %d Upgraded events in this chunk:\n", len(logs))
-				for i, logEntry := range logs {
-					fmt.Printf("    Event %d: tx=%s, block=%s, address=%s\n",
-						i+1, logEntry.TransactionHash, logEntry.BlockNumber, logEntry.Address)
-				}
-			}
+	// Scan each address individually from its creation block, several at a
+	// time; the workers share the session's rate limiter
+	runWorkers(pending, workers, func(i int) {
+		session.scanAddress(addresses[i], i+1, endBlock)
+	})
 
-			// Process transactions with 2+ events in this chunk
-			chunkDuplicates := 0
-			for txHash, txLogs := range chunkLogs {
-				if len(txLogs) >= 2 {
-					chunkDuplicates++
-					addressProgress.DuplicateTxs++
-					addressDuplicates++
-
-					// Only show duplicate details in DEBUG mode
-					if logLevel >= LOG_DEBUG {
-						fmt.Printf("\n  *** DUPLICATE FOUND *** Transaction %s has %d Upgraded events:\n", txHash, len(txLogs))
-						for i, txLog := range txLogs {
-							fmt.Printf("    Event %d: block=%s, address=%s\n", i+1, txLog.BlockNumber, txLog.Address)
-						}
-					}
-
-					// Get transaction details
-					fromAddress, err := getTransactionFrom(network.BlockscoutURL, txHash)
-					if err != nil {
-						logError("Error getting transaction details for %s: %v", txHash, err)
-						fromAddress = "Unknown"
-					}
-
-					// Use block number from first log
-					blockNumber := txLogs[0].BlockNumber
-
-					// Construct explorer link
-					explorerLink := fmt.Sprintf("%s/tx/%s", network.ExplorerURL, txHash)
-
-					// Write to CSV
-					writer.Write([]string{txHash, explorerLink, fromAddress, blockNumber})
-					addressProgress.ProcessedTxs++
-
-					// Rate limiting for transaction details
-					time.Sleep(config.RateLimit)
-				}
-			}
-
-			// Log chunk results (DEBUG level only)
-			if logLevel >= LOG_DEBUG {
-				avgAPITime := totalAPITime / time.Duration(requestCount)
-				fmt.Printf(" Found %d logs, %d duplicate txs (avg API: %v)\n",
-					len(logs), chunkDuplicates, avgAPITime.Truncate(time.Millisecond))
-			}
-
-			// Adaptive rate limiting
-			if apiDuration > 500*time.Millisecond {
-				time.Sleep(config.RateLimit * 2)
-			} else {
-				time.Sleep(config.RateLimit)
-			}
+	// Rescan blocks reorged out before or during the scan
+	for rescans := 0; rescans < maxReorgRescans; rescans++ {
+		if _, again := session.checkReorgs(endBlock); !again && !reorged {
+			break
 		}
+		reorged = false
+		session.followAddresses(addresses, endBlock)
+	}
 
-		// Mark address as processed and save progress
-		info.Processed = true
-		addressProgress.Addresses[address] = info
-		saveAddressProgress(progressFile, addressProgress)
-
-		completedAddresses++
-		remainingAddresses = totalAddressesToScan - completedAddresses
-		overallProgress := float64(completedAddresses) / float64(totalAddressesToScan) * 100
+	title := "Scan Complete"
+	if config.Watch {
+		title = "Backfill Complete"
+	}
+	printRunSummary(session, config, title, time.Since(startTime))
 
-		fmt.Printf("✅ Address %s complete: %d logs, %d duplicate transactions\n", address, addressLogs, addressDuplicates)
-		fmt.Printf("📊 Overall Progress: %d/%d (%.1f%%) | Remaining: %d addresses\n",
-			completedAddresses, totalAddressesToScan, overallProgress, remainingAddresses)
+	// Keep the progress while any range is still missing or any address
+	// still has to be classified
+	location := progressFile
+	if store != nil {
+		location = config.Store
+	}
+	gaps := countCoverageGaps(addressProgress)
+	if gaps > 0 {
+		progressf("⚠️  %d coverage gap(s) remain; progress kept in %s\n", gaps, location)
+		if store != nil {
+			progressf("   Retry them with: cpimp scan retry-gaps -store %s %s\n", config.Store, scanID)
+		} else {
+			progressf("   Retry them with: cpimp scan retry-gaps %s\n", scanID)
+		}
+	}
+	unclassified := addressesWithStatus(addressProgress, StatusAPIError)
+	if len(unclassified) > 0 {
+		progressf("⚠️  %d address(es) could not be looked up because of API errors; progress kept in %s\n", len(unclassified), location)
+		progressf("   Run the same scan again to retry them\n")
+	}
 
-		// Estimate time remaining
-		if completedAddresses > 0 && remainingAddresses > 0 {
-			elapsedSoFar := time.Since(startTime)
-			avgTimePerAddress := elapsedSoFar / time.Duration(completedAddresses)
-			estimatedTimeRemaining := avgTimePerAddress * time.Duration(remainingAddresses)
-			fmt.Printf("⏱️  Estimated time remaining: %v (avg: %v per address)\n",
-				estimatedTimeRemaining.Truncate(time.Second), avgTimePerAddress.Truncate(time.Second))
+	// A watched scan is never complete; its progress holds the head cursor
+	if config.Watch {
+		head := endBlock
+		if len(addresses) == 0 {
+			head = watchStartBlock(addressProgress, config, endBlock)
 		}
+		watchStart := time.Now()
+		session.watch(addresses, head)
+		printRunSummary(session, config, "Watch Stopped", time.Since(watchStart))
+		progressf("Progress kept in %s; run the same watch again to resume\n", location)
+		return
+	}
+	if gaps > 0 || len(unclassified) > 0 {
+		return
 	}
 
-	// Final summary
-	elapsed := time.Since(startTime)
-	fmt.Printf("\n=== Scan Complete (ID: %s) ===\n", scanID)
-	fmt.Printf("Total time: %v\n", elapsed.Truncate(time.Second))
+	completeScan(addressProgress, progressFile, store)
+}
+
+// printRunSummary reports the totals of a scan run
+func printRunSummary(session *scanSession, config ScannerConfig, title string, elapsed time.Duration) {
+	addressProgress := session.progress
+	progressf("\n=== %s (ID: %s) ===\n", title, addressProgress.ScanID)
+	progressf("Total time: %v\n", elapsed.Truncate(time.Second))
 
 	// Detailed results (INFO level and above)
-	if logLevel >= LOG_INFO {
-		fmt.Printf("Total logs found: %d\n", addressProgress.TotalLogs)
-		fmt.Printf("Total transactions with 2+ Upgraded events: %d\n", addressProgress.DuplicateTxs)
-		fmt.Printf("Total API calls: %d\n", requestCount)
-		if requestCount > 0 {
-			fmt.Printf("Average API response time: %v\n", (totalAPITime / time.Duration(requestCount)).Truncate(time.Millisecond))
+	if logEnabled(logger, slog.LevelInfo) {
+		progressf("Total logs found: %d\n", addressProgress.TotalLogs)
+		progressf("Total transactions with 2+ Upgraded events: %d\n", addressProgress.DuplicateTxs)
+		progressf("High severity (nested proxy) findings: %d\n", addressProgress.HighSeverityTxs)
+		for _, rule := range session.rules {
+			progressf("  %s: %d finding(s)\n", rule.Name, addressProgress.RuleFindings[rule.Name])
+		}
+		progressf("Total API calls: %d\n", session.requestCount)
+		progressf("Effective block range: min %d, avg %d, max %d (configured %d, %d splits)\n",
+			addressProgress.RangeStats.MinRange, addressProgress.RangeStats.AverageRange(),
+			addressProgress.RangeStats.MaxRange, config.BlockRange, addressProgress.RangeStats.Splits)
+		if addressProgress.RangeStats.TruncatedPages > 0 {
+			progressf("⚠️  %d single-block pages hit the %d result cap and may be truncated\n",
+				addressProgress.RangeStats.TruncatedPages, BlockscoutLogsCap)
 		}
+		if session.requestCount > 0 {
+			progressf("Average API response time: %v\n", (session.totalAPITime / time.Duration(session.requestCount)).Truncate(time.Millisecond))
+		}
+		for _, rate := range session.client.RateStats() {
+			progressf("Effective API rate for %s: %.2f req/s over %d requests (limit %.2f req/s)\n",
+				rate.Host, rate.Effective, rate.Requests, rate.Limit)
+			if rate.Throttled > 0 {
+				progressf("⚠️  %s rate limited %d request(s); rate ended at %.2f req/s\n", rate.Host, rate.Throttled, rate.Rate)
+			}
+		}
+		printClassificationBreakdown(progressOutput{}, *addressProgress, "")
 	}
-	fmt.Printf("Results saved to: %s\n", config.OutputFile)
-
-	// Clean up progress file on successful completion
-	os.Remove(progressFile)
-	fmt.Printf("Progress file %s removed (scan completed)\n", progressFile)
+	if session.retracted > 0 {
+		progressf("↩️  %d finding(s) retracted after reorgs\n", session.retracted)
+	}
+	progressf("Results saved to: %s\n", config.OutputFile)
 }
 
-func getLatestBlockNumber(blockscoutURL string) (uint64, error) {
+func getLatestBlockNumber(client *APIClient, blockscoutURL string) (uint64, error) {
 	// Try JSON-RPC format first (for Story network)
 	url := fmt.Sprintf("%s/api?module=block&action=eth_block_number", blockscoutURL)
 
-	resp, err := http.Get(url)
-	if err != nil {
-		return 0, err
-	}
-	defer resp.Body.Close()
-
-	body, err := io.ReadAll(resp.Body)
+	body, err := client.Get(url)
 	if err != nil {
 		return 0, err
 	}
@@ -819,18 +1057,17 @@ func getLatestBlockNumber(blockscoutURL string) (uint64, error) {
 	}
 	err = json.Unmarshal(body, &jsonRpcResp)
 	if err == nil && jsonRpcResp.Result != "" {
-		// Convert hex string to uint64 (remove 0x prefix)
-		blockNumber, err := strconv.ParseUint(jsonRpcResp.Result[2:], 16, 64)
+		// Convert hex string to uint64
+		blockNumber, err := parseHexUint64(jsonRpcResp.Result)
 		if err != nil {
-			return 0, err
+			return 0, &MalformedResponseError{URL: redactURL(url), Body: bodySnippet(body), Err: err}
 		}
 		return blockNumber, nil
 	}
 
 	// Fallback to Blockscout format
 	var blockResp BlockResponse
-	err = json.Unmarshal(body, &blockResp)
-	if err != nil {
+	if err := decodeResponse(url, body, &blockResp); err != nil {
 		return 0, err
 	}
 
@@ -846,9 +1083,12 @@ func getLatestBlockNumber(blockscoutURL string) (uint64, error) {
 	return blockNumber, nil
 }
 
-func fetchLogs(blockscoutURL, eventTopic string, fromBlock, toBlock uint64, targetAddresses []string) ([]LogEntry, error) {
-	url := fmt.Sprintf("%s/api?module=logs&action=getLogs&fromBlock=%d&toBlock=%d&topic0=%s",
-		blockscoutURL, fromBlock, toBlock, eventTopic)
+func fetchLogs(client *APIClient, blockscoutURL, eventTopic string, fromBlock, toBlock uint64, targetAddresses []string) ([]LogEntry, error) {
+	url := fmt.Sprintf("%s/api?module=logs&action=getLogs&fromBlock=%d&toBlock=%d",
+		blockscoutURL, fromBlock, toBlock)
+	if eventTopic != "" {
+		url += "&topic0=" + eventTopic
+	}
 
 	// Add address filter if target addresses are specified
 	if len(targetAddresses) > 0 {
@@ -857,43 +1097,19 @@ func fetchLogs(blockscoutURL, eventTopic string, fromBlock, toBlock uint64, targ
 		url += "&address=" + addressList
 	}
 
-	resp, err := http.Get(url)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
-	}
-
 	var apiResponse ApiResponse
-	err = json.Unmarshal(body, &apiResponse)
-	if err != nil {
+	if err := client.GetJSON(url, &apiResponse); err != nil {
 		return nil, err
 	}
 
 	return apiResponse.Result, nil
 }
 
-func getTransactionFrom(blockscoutURL, txHash string) (string, error) {
+func getTransactionFrom(client *APIClient, blockscoutURL, txHash string) (string, error) {
 	url := fmt.Sprintf("%s/api?module=proxy&action=eth_getTransactionByHash&txhash=%s", blockscoutURL, txHash)
 
-	resp, err := http.Get(url)
-	if err != nil {
-		return "", err
-	}
-	defer resp.Body.Close()
-
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return "", err
-	}
-
 	var txResponse TransactionResponse
-	err = json.Unmarshal(body, &txResponse)
-	if err != nil {
+	if err := client.GetJSON(url, &txResponse); err != nil {
 		return "", err
 	}
 
@@ -0,0 +1,415 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Metrics are exported in the Prometheus text exposition format, so any
+// Prometheus-compatible scraper can collect them from -metrics-addr
+
+// metricKind is a metric family's Prometheus type
+type metricKind string
+
+const (
+	metricCounter   metricKind = "counter"
+	metricGauge     metricKind = "gauge"
+	metricHistogram metricKind = "histogram"
+)
+
+// latencyBuckets are the upper bounds, in seconds, of the API latency
+// histogram
+var latencyBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+// metricFamily is one named metric with a value (or histogram) per set of
+// label values
+type metricFamily struct {
+	name    string
+	help    string
+	kind    metricKind
+	labels  []string
+	buckets []float64
+
+	mu     sync.Mutex
+	series map[string]*metricSeries
+}
+
+type metricSeries struct {
+	labelValues []string
+	value       float64
+	counts      []uint64 // per bucket, not cumulative
+	count       uint64
+	sum         float64
+}
+
+// metricsRegistry holds every metric family the scanner exports
+type metricsRegistry struct {
+	mu         sync.Mutex
+	families   []*metricFamily
+	collectors []func()
+}
+
+var metrics = &metricsRegistry{}
+
+var (
+	metricScanInfo = metrics.family("cpimp_scan_info", "Scan being run; always 1",
+		metricGauge, "scan_id", "network", "backend")
+	metricAPIRequests = metrics.family("cpimp_api_requests_total", "API requests sent, by endpoint and response status",
+		metricCounter, "endpoint", "status")
+	metricAPILatency = metrics.family("cpimp_api_request_duration_seconds", "API request latency, by endpoint",
+		metricHistogram, "endpoint")
+	metricAPIRetries = metrics.family("cpimp_api_retries_total", "API requests retried, by endpoint and reason",
+		metricCounter, "endpoint", "reason")
+	metricRateLimitBackoffs = metrics.family("cpimp_rate_limit_backoffs_total", "Times a host rate limited the scanner and requests to it were slowed",
+		metricCounter, "host")
+	metricRateLimit = metrics.family("cpimp_rate_limit_requests_per_second", "Current request rate allowed to each host",
+		metricGauge, "host")
+	metricChunkRetries = metrics.family("cpimp_chunk_retries_total", "Coverage gaps retried",
+		metricCounter)
+	metricLogsFetched = metrics.family("cpimp_logs_fetched_total", "Event logs fetched",
+		metricCounter)
+	metricFindings = metrics.family("cpimp_findings_total", "Findings recorded, by rule and severity",
+		metricCounter, "rule", "severity")
+	metricFindingsRetracted = metrics.family("cpimp_findings_retracted_total", "Findings retracted after reorgs, by rule",
+		metricCounter, "rule")
+	metricAddressesTotal = metrics.family("cpimp_addresses_total", "Addresses being scanned",
+		metricGauge)
+	metricAddressesCompleted = metrics.family("cpimp_addresses_completed", "Addresses scanned up to the end block",
+		metricGauge)
+	metricAddressesRemaining = metrics.family("cpimp_addresses_remaining", "Addresses not yet scanned up to the end block",
+		metricGauge)
+	metricAddressBlock = metrics.family("cpimp_address_block", "Last block scanned for each address",
+		metricGauge, "address")
+	metricHeadBlock = metrics.family("cpimp_head_block", "Block the scan of all addresses (or the watch) has followed the chain through",
+		metricGauge)
+	metricChainHead = metrics.family("cpimp_chain_head_block", "Latest chain block seen",
+		metricGauge)
+	metricETA = metrics.family("cpimp_eta_seconds", "Estimated time until the backfill finishes",
+		metricGauge)
+	metricAlerts = metrics.family("cpimp_alerts_total", "Alerts delivered or dead-lettered, by sink and result",
+		metricCounter, "sink", "result")
+)
+
+// family registers a metric family
+func (r *metricsRegistry) family(name, help string, kind metricKind, labels ...string) *metricFamily {
+	f := &metricFamily{name: name, help: help, kind: kind, labels: labels, series: make(map[string]*metricSeries)}
+	if kind == metricHistogram {
+		f.buckets = latencyBuckets
+	}
+	r.mu.Lock()
+	r.families = append(r.families, f)
+	r.mu.Unlock()
+	return f
+}
+
+// onScrape registers a function that refreshes gauges before each scrape
+func (r *metricsRegistry) onScrape(collect func()) {
+	r.mu.Lock()
+	r.collectors = append(r.collectors, collect)
+	r.mu.Unlock()
+}
+
+// get returns the series of the label values, creating it. A sample with
+// the wrong number of label values is an error, and is dropped by the
+// callers. The caller must hold mu.
+func (f *metricFamily) get(labelValues []string) (*metricSeries, error) {
+	if len(labelValues) != len(f.labels) {
+		return nil, fmt.Errorf("metric %s takes %d labels, got %d", f.name, len(f.labels), len(labelValues))
+	}
+	key := strings.Join(labelValues, "\xff")
+	s, ok := f.series[key]
+	if !ok {
+		s = &metricSeries{labelValues: append([]string{}, labelValues...)}
+		if f.kind == metricHistogram {
+			s.counts = make([]uint64, len(f.buckets))
+		}
+		f.series[key] = s
+	}
+	return s, nil
+}
+
+// seriesFor is get for the exported methods: it logs errors instead of
+// returning them, so a bad sample never fails the request that recorded it
+func (f *metricFamily) seriesFor(labelValues []string) (*metricSeries, bool) {
+	s, err := f.get(labelValues)
+	if err != nil {
+		logger.Error("Dropped metric sample", "err", err)
+		return nil, false
+	}
+	return s, true
+}
+
+// Add adds v to a counter or gauge
+func (f *metricFamily) Add(v float64, labelValues ...string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if s, ok := f.seriesFor(labelValues); ok {
+		s.value += v
+	}
+}
+
+// Inc adds one to a counter or gauge
+func (f *metricFamily) Inc(labelValues ...string) {
+	f.Add(1, labelValues...)
+}
+
+// Set sets a gauge
+func (f *metricFamily) Set(v float64, labelValues ...string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if s, ok := f.seriesFor(labelValues); ok {
+		s.value = v
+	}
+}
+
+// Observe records a sample in a histogram
+func (f *metricFamily) Observe(v float64, labelValues ...string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	s, ok := f.seriesFor(labelValues)
+	if !ok {
+		return
+	}
+	for i, bound := range f.buckets {
+		if v <= bound {
+			s.counts[i]++
+			break
+		}
+	}
+	s.count++
+	s.sum += v
+}
+
+// Reset drops every series, for gauges whose label values go away
+func (f *metricFamily) Reset() {
+	f.mu.Lock()
+	f.series = make(map[string]*metricSeries)
+	f.mu.Unlock()
+}
+
+// WriteTo writes every metric in the Prometheus text format
+func (r *metricsRegistry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	families := append([]*metricFamily{}, r.families...)
+	collectors := append([]func(){}, r.collectors...)
+	r.mu.Unlock()
+	for _, collect := range collectors {
+		collect()
+	}
+	sort.Slice(families, func(i, j int) bool { return families[i].name < families[j].name })
+
+	var b strings.Builder
+	for _, f := range families {
+		f.write(&b)
+	}
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
+
+func (f *metricFamily) write(b *strings.Builder) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s %s\n", f.name, f.help, f.name, f.kind)
+	// Unlabelled counters and gauges start out at zero
+	if len(f.labels) == 0 && f.kind != metricHistogram {
+		_, _ = f.get(nil)
+	}
+
+	keys := make([]string, 0, len(f.series))
+	for key := range f.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		s := f.series[key]
+		if f.kind != metricHistogram {
+			fmt.Fprintf(b, "%s%s %s\n", f.name, formatLabels(f.labels, s.labelValues, "", ""), formatValue(s.value))
+			continue
+		}
+		var cumulative uint64
+		for i, bound := range f.buckets {
+			cumulative += s.counts[i]
+			fmt.Fprintf(b, "%s_bucket%s %d\n", f.name, formatLabels(f.labels, s.labelValues, "le", formatValue(bound)), cumulative)
+		}
+		fmt.Fprintf(b, "%s_bucket%s %d\n", f.name, formatLabels(f.labels, s.labelValues, "le", "+Inf"), s.count)
+		fmt.Fprintf(b, "%s_sum%s %s\n", f.name, formatLabels(f.labels, s.labelValues, "", ""), formatValue(s.sum))
+		fmt.Fprintf(b, "%s_count%s %d\n", f.name, formatLabels(f.labels, s.labelValues, "", ""), s.count)
+	}
+}
+
+// formatLabels renders a label set, with an optional extra label (le)
+func formatLabels(names, values []string, extraName, extraValue string) string {
+	var pairs []string
+	for i, name := range names {
+		pairs = append(pairs, fmt.Sprintf("%s=\"%s\"", name, escapeLabel(values[i])))
+	}
+	if extraName != "" {
+		pairs = append(pairs, fmt.Sprintf("%s=\"%s\"", extraName, extraValue))
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(value string) string {
+	return labelEscaper.Replace(value)
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// startMetricsServer serves the metrics on addr at /metrics until the
+// process exits
+func startMetricsServer(addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen for metrics on %s: %v", addr, err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		if _, err := metrics.WriteTo(w); err != nil {
+			logger.Debug("Failed to write metrics", "err", err)
+		}
+	})
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		if err := server.Serve(listener); err != nil {
+			logger.Error("Metrics server stopped", "addr", addr, "err", err)
+		}
+	}()
+	progressf("📈 Serving metrics at http://%s/metrics\n", listener.Addr())
+	return nil
+}
+
+// hexSegment matches URL path segments holding an address or hash, which
+// would give every contract its own endpoint label
+var hexSegment = regexp.MustCompile(`^0x[0-9a-fA-F]+$`)
+
+// keySegment matches URL path segments that look like an API key, as in
+// RPC URLs such as https://eth-mainnet.g.alchemy.com/v2/<key>
+var keySegment = regexp.MustCompile(`^[0-9A-Za-z_-]{20,}$`)
+
+// endpointLabel names the endpoint of rawURL for metrics: its host and path,
+// with addresses and API keys elided, plus the module and action of
+// Etherscan-style APIs. The rest of the query is left out.
+func endpointLabel(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return "unknown"
+	}
+	segments := strings.Split(u.Path, "/")
+	for i, segment := range segments {
+		switch {
+		case hexSegment.MatchString(segment):
+			segments[i] = ":address"
+		case keySegment.MatchString(segment):
+			segments[i] = ":key"
+		}
+	}
+	label := u.Host + strings.Join(segments, "/")
+	query := u.Query()
+	if module, action := query.Get("module"), query.Get("action"); module != "" && action != "" {
+		label += "?module=" + module + "&action=" + action
+	}
+	return label
+}
+
+// observeAPIRequest records the outcome of one API request. status is the
+// HTTP status code, or 0 when no response arrived.
+func observeAPIRequest(rawURL string, status int, duration time.Duration) {
+	endpoint := endpointLabel(rawURL)
+	label := "error"
+	if status != 0 {
+		label = strconv.Itoa(status)
+	}
+	metricAPIRequests.Inc(endpoint, label)
+	metricAPILatency.Observe(duration.Seconds(), endpoint)
+}
+
+// retryReason names why an API request is retried
+func retryReason(err error) string {
+	switch err.(type) {
+	case *RateLimitError:
+		return "rate_limited"
+	case *HTTPStatusError:
+		return "server_error"
+	}
+	return "transport"
+}
+
+// The session whose progress the scrape collector reports; the collector
+// is registered once, by the first registerScanMetrics
+var (
+	scrapedSessionMu  sync.Mutex
+	scrapedSession    *scanSession
+	scanCollectorOnce sync.Once
+)
+
+// registerScanMetrics exports the scan's identity, and refreshes the gauges
+// of its progress and of the per-host request rates on every scrape. A later
+// scan in the same process replaces the earlier one.
+func registerScanMetrics(s *scanSession) {
+	metricScanInfo.Reset()
+	metricScanInfo.Set(1, s.progress.ScanID, s.network.Name, s.source.Name())
+	scrapedSessionMu.Lock()
+	scrapedSession = s
+	scrapedSessionMu.Unlock()
+	scanCollectorOnce.Do(func() { metrics.onScrape(collectScanMetrics) })
+}
+
+// collectScanMetrics refreshes the gauges of the current scan
+func collectScanMetrics() {
+	scrapedSessionMu.Lock()
+	s := scrapedSession
+	scrapedSessionMu.Unlock()
+	if s == nil {
+		return
+	}
+
+	for _, stats := range s.client.RateStats() {
+		metricRateLimit.Set(stats.Rate, stats.Host)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	metricAddressBlock.Reset()
+	for address, info := range s.progress.Addresses {
+		if info.Scannable() {
+			metricAddressBlock.Set(float64(info.LastScannedBlock), address)
+		}
+	}
+	metricHeadBlock.Set(float64(s.progress.HeadBlock))
+
+	remaining := s.totalAddresses - s.completedAddresses
+	metricAddressesTotal.Set(float64(s.totalAddresses))
+	metricAddressesCompleted.Set(float64(s.completedAddresses))
+	metricAddressesRemaining.Set(float64(remaining))
+	// Same estimate as the progress lines: the average time per address
+	// completed so far
+	eta := 0.0
+	if remaining > 0 && s.completedAddresses > 0 {
+		eta = time.Since(s.started).Seconds() / float64(s.completedAddresses) * float64(remaining)
+	}
+	metricETA.Set(eta)
+}
@@ -0,0 +1,314 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Environment variable naming a networks registry file, and the file picked
+// up from the working directory when the variable is not set
+const (
+	EnvNetworksFile     = "CPIMP_NETWORKS_FILE"
+	DefaultNetworksFile = "networks.yaml"
+)
+
+// NetworkFileEntry is the on-disk representation of a NetworkConfig. For an
+// existing network only the fields that are set are overridden.
+type NetworkFileEntry struct {
+	Name          *string `json:"name,omitempty" yaml:"name,omitempty"`
+	ChainID       *uint64 `json:"chain_id,omitempty" yaml:"chain_id,omitempty"`
+	BlockscoutURL *string `json:"blockscout_url,omitempty" yaml:"blockscout_url,omitempty"`
+	ExplorerURL   *string `json:"explorer_url,omitempty" yaml:"explorer_url,omitempty"`
+	ExplorerTxURL *string `json:"explorer_tx_url,omitempty" yaml:"explorer_tx_url,omitempty"`
+	RPCURL        *string `json:"rpc_url,omitempty" yaml:"rpc_url,omitempty"`
+	Backend       *string `json:"backend,omitempty" yaml:"backend,omitempty"`
+	EtherscanURL  *string `json:"etherscan_url,omitempty" yaml:"etherscan_url,omitempty"`
+	APIKey        *string `json:"api_key,omitempty" yaml:"api_key,omitempty"`
+	APIKeyEnv     *string `json:"api_key_env,omitempty" yaml:"api_key_env,omitempty"`
+	BlockRange    *uint64 `json:"block_range,omitempty" yaml:"block_range,omitempty"`
+	RateLimit     *string `json:"rate_limit,omitempty" yaml:"rate_limit,omitempty"`
+	Concurrency   *int    `json:"concurrency,omitempty" yaml:"concurrency,omitempty"`
+	Confirmations *uint64 `json:"confirmations,omitempty" yaml:"confirmations,omitempty"`
+}
+
+// NetworkRegistryFile is the on-disk networks registry
+type NetworkRegistryFile struct {
+	Networks map[string]NetworkFileEntry `json:"networks" yaml:"networks"`
+}
+
+// defaultNetworksFile returns the registry file to load when none is given
+// explicitly ("" if there is none)
+func defaultNetworksFile() string {
+	if path := os.Getenv(EnvNetworksFile); path != "" {
+		return path
+	}
+	if _, err := os.Stat(DefaultNetworksFile); err == nil {
+		return DefaultNetworksFile
+	}
+	return ""
+}
+
+// LoadNetworkRegistry reads a YAML or JSON networks registry file and adds
+// or overrides the entries of the Networks map
+func LoadNetworkRegistry(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read networks file: %v", err)
+	}
+
+	var registry NetworkRegistryFile
+	if err := unmarshalConfigData(path, data, &registry); err != nil {
+		return fmt.Errorf("failed to parse networks file %s: %v", path, err)
+	}
+
+	// Sort keys so errors are reported deterministically
+	keys := make([]string, 0, len(registry.Networks))
+	for key := range registry.Networks {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		network, err := registry.Networks[key].apply(Networks[key])
+		if err != nil {
+			return fmt.Errorf("network %q in %s: %v", key, path, err)
+		}
+		if network.Name == "" {
+			network.Name = key
+		}
+		if err := network.Validate(); err != nil {
+			return fmt.Errorf("network %q in %s: %v", key, path, err)
+		}
+		Networks[key] = network
+	}
+
+	logger.Info("Loaded networks registry", "file", path, "networks", len(keys))
+	return nil
+}
+
+// apply overlays the fields set in the entry onto network
+func (e NetworkFileEntry) apply(network NetworkConfig) (NetworkConfig, error) {
+	if e.Name != nil {
+		network.Name = *e.Name
+	}
+	if e.ChainID != nil {
+		network.ChainID = *e.ChainID
+	}
+	if e.BlockscoutURL != nil {
+		network.BlockscoutURL = strings.TrimRight(*e.BlockscoutURL, "/")
+	}
+	if e.ExplorerURL != nil {
+		network.ExplorerURL = strings.TrimRight(*e.ExplorerURL, "/")
+	}
+	if e.ExplorerTxURL != nil {
+		network.ExplorerTxURL = *e.ExplorerTxURL
+	}
+	if e.RPCURL != nil {
+		network.RPCURL = *e.RPCURL
+	}
+	if e.Backend != nil {
+		network.Backend = *e.Backend
+	}
+	if e.EtherscanURL != nil {
+		network.EtherscanURL = *e.EtherscanURL
+	}
+	if e.APIKey != nil {
+		network.APIKey = *e.APIKey
+	}
+	if e.APIKeyEnv != nil {
+		network.APIKeyEnv = *e.APIKeyEnv
+	}
+	if e.BlockRange != nil {
+		network.DefaultBlockRange = *e.BlockRange
+	}
+	if e.RateLimit != nil {
+		rateLimit, err := time.ParseDuration(*e.RateLimit)
+		if err != nil {
+			return network, fmt.Errorf("invalid rate_limit %q: %v", *e.RateLimit, err)
+		}
+		network.DefaultRateLimit = rateLimit
+	}
+	if e.Concurrency != nil {
+		network.DefaultConcurrency = *e.Concurrency
+	}
+	if e.Confirmations != nil {
+		network.DefaultConfirmations = *e.Confirmations
+	}
+
+	if network.ExplorerURL == "" {
+		network.ExplorerURL = network.BlockscoutURL
+	}
+	return network, nil
+}
+
+// Validate checks that a network entry is usable
+func (n NetworkConfig) Validate() error {
+	if n.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	if n.Backend != "" && !isKnownBackend(n.Backend) {
+		return fmt.Errorf("unknown backend %q", n.Backend)
+	}
+	if n.Backend == BackendRPC && n.RPCURL == "" {
+		return fmt.Errorf("backend %q requires rpc_url", n.Backend)
+	}
+	if n.Backend == BackendEtherscan && n.EtherscanURL == "" {
+		return fmt.Errorf("backend %q requires etherscan_url", n.Backend)
+	}
+	if n.EtherscanURL != "" {
+		if err := validateHTTPURL("etherscan_url", n.EtherscanURL); err != nil {
+			return err
+		}
+	}
+	// Networks served by another backend don't need a Blockscout instance
+	if n.Backend == "" || n.Backend == BackendBlockscout || n.BlockscoutURL != "" {
+		if err := validateHTTPURL("blockscout_url", n.BlockscoutURL); err != nil {
+			return err
+		}
+	}
+	if n.ExplorerURL != "" {
+		if err := validateHTTPURL("explorer_url", n.ExplorerURL); err != nil {
+			return err
+		}
+	}
+	if n.RPCURL != "" {
+		if err := validateHTTPURL("rpc_url", n.RPCURL); err != nil {
+			return err
+		}
+	}
+	if n.ExplorerTxURL != "" && !strings.Contains(n.ExplorerTxURL, "{tx}") {
+		return fmt.Errorf("explorer_tx_url must contain the {tx} placeholder")
+	}
+	if n.DefaultConcurrency < 0 {
+		return fmt.Errorf("concurrency must not be negative")
+	}
+	return nil
+}
+
+func validateHTTPURL(field, raw string) error {
+	if raw == "" {
+		return fmt.Errorf("%s is required", field)
+	}
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid %s %q: must be an http(s) URL", field, raw)
+	}
+	return nil
+}
+
+// errNoRPCEndpoint means a network has no endpoint to ask for its chain ID
+var errNoRPCEndpoint = errors.New("no RPC endpoint")
+
+// fetchChainID asks the network's JSON-RPC endpoint (or, if none is
+// configured, Blockscout's eth-rpc endpoint) for its chain ID. Etherscan's
+// proxy module has no eth_chainId, so Etherscan-only networks can't be asked.
+func fetchChainID(client *APIClient, network NetworkConfig) (uint64, error) {
+	endpoint := network.RPCURL
+	if endpoint == "" && network.BlockscoutURL != "" {
+		endpoint = network.BlockscoutURL + "/api/eth-rpc"
+	}
+	if endpoint == "" {
+		return 0, errNoRPCEndpoint
+	}
+
+	var result string
+	if err := rpcCall(client, endpoint, "eth_chainId", nil, &result); err != nil {
+		return 0, fmt.Errorf("failed to fetch chain ID: %v", err)
+	}
+
+	chainID, err := parseHexUint64(result)
+	if err != nil {
+		return 0, fmt.Errorf("invalid chain ID %q: %v", result, err)
+	}
+	return chainID, nil
+}
+
+// verifyChainID checks that the endpoint serves the configured chain. A
+// network without a configured chain ID is not checked; one without an
+// endpoint to ask returns errNoRPCEndpoint.
+func verifyChainID(client *APIClient, network NetworkConfig) error {
+	if network.ChainID == 0 {
+		return nil
+	}
+
+	chainID, err := fetchChainID(client, network)
+	if err != nil {
+		return err
+	}
+	if chainID != network.ChainID {
+		return &ChainIDMismatchError{Network: network.Name, Configured: network.ChainID, Reported: chainID}
+	}
+	return nil
+}
+
+// ChainIDMismatchError reports an endpoint serving a different chain than configured
+type ChainIDMismatchError struct {
+	Network    string
+	Configured uint64
+	Reported   uint64
+}
+
+func (e *ChainIDMismatchError) Error() string {
+	return fmt.Sprintf("chain ID mismatch for %s: configured %d, endpoint reports %d", e.Network, e.Configured, e.Reported)
+}
+
+// ListNetworks prints the network registry, optionally checking each
+// network's chain ID against its endpoint. Returns false if any check failed.
+func ListNetworks(check bool) bool {
+	keys := make([]string, 0, len(Networks))
+	for key := range Networks {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	client := NewAPIClient(FallbackRateLimit)
+	ok := true
+	for _, key := range keys {
+		network := Networks[key]
+		fmt.Printf("%s:\n", key)
+		fmt.Printf("  Name: %s\n", network.Name)
+		fmt.Printf("  Chain ID: %d\n", network.ChainID)
+		fmt.Printf("  Backend: %s\n", backendName(ScannerConfig{}, network))
+		if network.BlockscoutURL != "" {
+			fmt.Printf("  Blockscout URL: %s\n", redactURL(network.BlockscoutURL))
+		}
+		fmt.Printf("  Explorer Tx URL: %s\n", network.TxURL("{tx}"))
+		if network.RPCURL != "" {
+			fmt.Printf("  RPC URL: %s\n", redactURL(network.RPCURL))
+		}
+		if network.EtherscanURL != "" {
+			fmt.Printf("  Etherscan URL: %s\n", redactURL(network.EtherscanURL))
+		}
+		if network.APIKeyEnv != "" {
+			fmt.Printf("  API Key: from $%s\n", network.APIKeyEnv)
+		} else if network.APIKey != "" {
+			fmt.Printf("  API Key: (set)\n")
+		}
+		fmt.Printf("  Default Block Range: %d\n", network.DefaultBlockRange)
+		fmt.Printf("  Default Rate Limit: %v\n", network.DefaultRateLimit)
+		if network.DefaultConcurrency > 0 {
+			fmt.Printf("  Default Concurrency: %d\n", network.DefaultConcurrency)
+		}
+		fmt.Printf("  Confirmations: %d\n", network.DefaultConfirmations)
+
+		if check {
+			if err := verifyChainID(client, network); errors.Is(err, errNoRPCEndpoint) {
+				fmt.Printf("  Chain ID Check: skipped (no RPC endpoint)\n")
+			} else if err != nil {
+				fmt.Printf("  Chain ID Check: FAILED (%v)\n", err)
+				ok = false
+			} else if network.ChainID == 0 {
+				fmt.Printf("  Chain ID Check: skipped (no chain ID configured)\n")
+			} else {
+				fmt.Printf("  Chain ID Check: OK\n")
+			}
+		}
+		fmt.Println()
+	}
+	return ok
+}
@@ -0,0 +1,444 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// Supported findings output formats
+const (
+	OutputCSV    = "csv"
+	OutputJSON   = "json"
+	OutputNDJSON = "ndjson"
+)
+
+// FindingsSchemaVersion is the version of the JSON/NDJSON finding record.
+// It is bumped whenever a field is removed or changes meaning; adding a
+// field doesn't change it.
+const FindingsSchemaVersion = 1
+
+// FindingsWriter records findings, skipping ones already in the output so
+// that rescanning a range after a restart does not duplicate them
+type FindingsWriter interface {
+	// Write records a finding. Returns false for a skipped duplicate.
+	Write(finding Finding) bool
+	// Retract appends a retraction record for a finding whose block was
+	// reorged out; the finding is written again if it is found again.
+	// Returns false if the finding isn't in the output.
+	Retract(finding Finding) bool
+	Flush() error
+	Close() error
+}
+
+// isKnownOutputFormat reports whether format is a supported output format
+func isKnownOutputFormat(format string) bool {
+	switch format {
+	case OutputCSV, OutputJSON, OutputNDJSON:
+		return true
+	}
+	return false
+}
+
+// openFindingsWriter opens the output file in the given format ("" = csv)
+func openFindingsWriter(path, format string) (FindingsWriter, error) {
+	switch format {
+	case "", OutputCSV:
+		return openResultsCSV(path)
+	case OutputNDJSON:
+		return openNDJSONWriter(path)
+	case OutputJSON:
+		return openJSONWriter(path)
+	default:
+		return nil, fmt.Errorf("unknown output format %q", format)
+	}
+}
+
+// findingRecord is a finding as written to JSON and NDJSON output
+type findingRecord struct {
+	SchemaVersion int `json:"schema_version"`
+	Finding
+
+	// Retracted marks a record withdrawing an earlier one with the same
+	// transaction, proxy and rule, whose block was reorged out
+	Retracted bool `json:"retracted,omitempty"`
+}
+
+// findingStatusRetracted is the Status column of a retraction row in the CSV
+const findingStatusRetracted = "retracted"
+
+// findingKey identifies a finding for deduplication
+func findingKey(txHash, proxy, rule string) string {
+	return strings.ToLower(txHash) + "\x00" + strings.ToLower(proxy) + "\x00" + rule
+}
+
+// resultsWriter appends findings to the results CSV, skipping findings that
+// are already in the file so that rescanning a chunk after a restart does not
+// duplicate them. Rows are matched by finding key rather than content, since
+// the sender and implementation chain of a re-detected finding can differ.
+type resultsWriter struct {
+	file   *os.File
+	writer *csv.Writer
+	rows   map[string][]string // finding key -> row as written, for live findings
+}
+
+// openResultsCSV opens the results CSV for appending, writing the header if
+// the file is new and loading the existing findings for deduplication
+func openResultsCSV(path string) (*resultsWriter, error) {
+	rows, header, err := loadCSVFindings(path)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &resultsWriter{file: file, writer: csv.NewWriter(file), rows: rows}
+
+	// Write CSV header only if file is empty
+	fileInfo, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	if fileInfo.Size() == 0 {
+		w.writer.Write(resultsCSVHeader)
+	} else if strings.Join(header, "\x00") != strings.Join(resultsCSVHeader, "\x00") {
+		file.Close()
+		return nil, fmt.Errorf("%s was written with another column layout; use a new -output", path)
+	}
+
+	return w, nil
+}
+
+// loadCSVFindings returns the rows of the live findings in a results CSV by
+// finding key, and its header row. Rows in another column layout aren't keyed.
+func loadCSVFindings(path string) (map[string][]string, []string, error) {
+	rows := make(map[string][]string)
+
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return rows, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+	var header []string
+	for {
+		row, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read existing rows from %s: %v", path, err)
+		}
+		if header == nil {
+			header = row
+			continue
+		}
+		key, ok := csvRowKey(row)
+		if !ok {
+			continue
+		}
+		// A retraction row withdraws the finding written before it
+		if row[len(row)-1] == findingStatusRetracted {
+			delete(rows, key)
+		} else {
+			rows[key] = row
+		}
+	}
+	return rows, header, nil
+}
+
+// csvRowKey returns the finding key of a row in the resultsCSVHeader layout
+func csvRowKey(row []string) (string, bool) {
+	if len(row) != len(resultsCSVHeader) {
+		return "", false
+	}
+	// Transaction Hash, Proxy Address and Rule columns
+	return findingKey(row[0], row[4], row[9]), true
+}
+
+func (w *resultsWriter) Write(finding Finding) bool {
+	key := findingKey(finding.TxHash, finding.Proxy, finding.Rule)
+	if _, ok := w.rows[key]; ok {
+		return false
+	}
+	row := finding.CSVRow()
+	w.rows[key] = row
+	w.writer.Write(row)
+	return true
+}
+
+// Retract repeats the row as it was written, with the retracted status, so
+// readers can match it to the original even if the finding has changed since
+func (w *resultsWriter) Retract(finding Finding) bool {
+	key := findingKey(finding.TxHash, finding.Proxy, finding.Rule)
+	row, ok := w.rows[key]
+	if !ok {
+		return false
+	}
+	delete(w.rows, key)
+	retraction := append([]string(nil), row...)
+	retraction[len(retraction)-1] = findingStatusRetracted
+	w.writer.Write(retraction)
+	return true
+}
+
+func (w *resultsWriter) Flush() error {
+	w.writer.Flush()
+	return w.writer.Error()
+}
+
+func (w *resultsWriter) Close() error {
+	w.writer.Flush()
+	return w.file.Close()
+}
+
+// ndjsonWriter appends one JSON finding record per line
+type ndjsonWriter struct {
+	file   *os.File
+	writer *bufio.Writer
+	seen   map[string]bool
+}
+
+func openNDJSONWriter(path string) (*ndjsonWriter, error) {
+	seen, validSize, err := loadNDJSONFindings(path)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	// Drop a record cut short by a crash so the next one starts on its own line
+	if info, err := file.Stat(); err == nil && info.Size() > validSize {
+		logger.Warn("Discarding incomplete last record of output file", "file", path)
+		if err := file.Truncate(validSize); err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+	if _, err := file.Seek(0, io.SeekEnd); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &ndjsonWriter{file: file, writer: bufio.NewWriter(file), seen: seen}, nil
+}
+
+// loadNDJSONFindings returns the keys of the findings already in an NDJSON
+// file and the size of its complete records. Only an unterminated last
+// line may fail to parse.
+func loadNDJSONFindings(path string) (map[string]bool, int64, error) {
+	seen := make(map[string]bool)
+
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return seen, 0, nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	var size int64
+	for line := 1; ; line++ {
+		data, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			// Anything left without a newline is an incomplete record
+			return seen, size, nil
+		}
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to read existing findings from %s: %v", path, err)
+		}
+
+		if strings.TrimSpace(string(data)) != "" {
+			var record findingRecord
+			if err := json.Unmarshal(data, &record); err != nil {
+				return nil, 0, fmt.Errorf("failed to read existing findings from %s (line %d): %v", path, line, err)
+			}
+			key := findingKey(record.TxHash, record.Proxy, record.Rule)
+			seen[key] = !record.Retracted
+		}
+		size += int64(len(data))
+	}
+}
+
+func (w *ndjsonWriter) Write(finding Finding) bool {
+	key := findingKey(finding.TxHash, finding.Proxy, finding.Rule)
+	if w.seen[key] {
+		return false
+	}
+	if !w.writeRecord(findingRecord{SchemaVersion: FindingsSchemaVersion, Finding: finding}) {
+		return false
+	}
+	w.seen[key] = true
+	return true
+}
+
+func (w *ndjsonWriter) Retract(finding Finding) bool {
+	key := findingKey(finding.TxHash, finding.Proxy, finding.Rule)
+	if !w.seen[key] {
+		return false
+	}
+	if !w.writeRecord(findingRecord{SchemaVersion: FindingsSchemaVersion, Finding: finding, Retracted: true}) {
+		return false
+	}
+	w.seen[key] = false
+	return true
+}
+
+// writeRecord appends a record as one line
+func (w *ndjsonWriter) writeRecord(record findingRecord) bool {
+	line, err := json.Marshal(record)
+	if err != nil {
+		detectorLogger.Error("Failed to encode finding", "tx", record.TxHash, "err", err)
+		return false
+	}
+	w.writer.Write(line)
+	w.writer.WriteByte('\n')
+	return true
+}
+
+func (w *ndjsonWriter) Flush() error {
+	return w.writer.Flush()
+}
+
+func (w *ndjsonWriter) Close() error {
+	w.writer.Flush()
+	return w.file.Close()
+}
+
+// jsonFindingsFile is the document written in json format
+type jsonFindingsFile struct {
+	SchemaVersion int               `json:"schema_version"`
+	Findings      []json.RawMessage `json:"findings"`
+}
+
+// Rewriting the json document costs its whole size, so Flush only writes it
+// every jsonFlushInterval or jsonFlushRecords new records; Close always does
+const (
+	jsonFlushInterval = 30 * time.Second
+	jsonFlushRecords  = 100
+)
+
+// jsonWriter keeps every finding in memory and rewrites the whole document
+// through a temporary file, so readers never see it half written
+type jsonWriter struct {
+	path      string
+	findings  []json.RawMessage
+	seen      map[string]bool
+	pending   int // records added since the last write
+	lastWrite time.Time
+}
+
+func openJSONWriter(path string) (*jsonWriter, error) {
+	w := &jsonWriter{path: path, findings: []json.RawMessage{}, seen: make(map[string]bool)}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		if err := w.write(); err != nil {
+			return nil, err
+		}
+		return w, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var existing jsonFindingsFile
+	if err := json.Unmarshal(data, &existing); err != nil {
+		return nil, fmt.Errorf("failed to read existing findings from %s: %v", path, err)
+	}
+	if existing.SchemaVersion != FindingsSchemaVersion {
+		return nil, fmt.Errorf("%s has findings schema version %d, expected %d; use a new -output", path, existing.SchemaVersion, FindingsSchemaVersion)
+	}
+	for _, raw := range existing.Findings {
+		var record findingRecord
+		if err := json.Unmarshal(raw, &record); err != nil {
+			return nil, fmt.Errorf("failed to read existing findings from %s: %v", path, err)
+		}
+		w.seen[findingKey(record.TxHash, record.Proxy, record.Rule)] = !record.Retracted
+		w.findings = append(w.findings, raw)
+	}
+	w.lastWrite = time.Now()
+	return w, nil
+}
+
+func (w *jsonWriter) Write(finding Finding) bool {
+	key := findingKey(finding.TxHash, finding.Proxy, finding.Rule)
+	if w.seen[key] {
+		return false
+	}
+	if !w.appendRecord(findingRecord{SchemaVersion: FindingsSchemaVersion, Finding: finding}) {
+		return false
+	}
+	w.seen[key] = true
+	return true
+}
+
+func (w *jsonWriter) Retract(finding Finding) bool {
+	key := findingKey(finding.TxHash, finding.Proxy, finding.Rule)
+	if !w.seen[key] {
+		return false
+	}
+	if !w.appendRecord(findingRecord{SchemaVersion: FindingsSchemaVersion, Finding: finding, Retracted: true}) {
+		return false
+	}
+	w.seen[key] = false
+	return true
+}
+
+// appendRecord adds a record to the document
+func (w *jsonWriter) appendRecord(record findingRecord) bool {
+	raw, err := json.Marshal(record)
+	if err != nil {
+		detectorLogger.Error("Failed to encode finding", "tx", record.TxHash, "err", err)
+		return false
+	}
+	w.findings = append(w.findings, raw)
+	w.pending++
+	return true
+}
+
+// Flush writes the document once enough records or time have accumulated
+func (w *jsonWriter) Flush() error {
+	if w.pending == 0 || (w.pending < jsonFlushRecords && time.Since(w.lastWrite) < jsonFlushInterval) {
+		return nil
+	}
+	return w.write()
+}
+
+func (w *jsonWriter) Close() error {
+	if w.pending == 0 {
+		return nil
+	}
+	return w.write()
+}
+
+// write rewrites the whole document
+func (w *jsonWriter) write() error {
+	data, err := json.MarshalIndent(jsonFindingsFile{SchemaVersion: FindingsSchemaVersion, Findings: w.findings}, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(w.path, append(data, '\n'), ""); err != nil {
+		return err
+	}
+	w.pending = 0
+	w.lastWrite = time.Now()
+	return nil
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+)
+
+// When an endpoint answers 429 its request rate is halved, down to this
+// fraction of the configured rate; every successful request then gives back
+// rateRecoveryStep of the configured rate until it is reached again
+const (
+	minRateFraction  = 1.0 / 16
+	rateRecoveryStep = 0.1
+)
+
+// tokenBucket limits the requests sent to one endpoint host. It refills at
+// rate tokens per second up to burst; a request that finds the bucket empty
+// reserves the next token and sleeps until it is due, so concurrent callers
+// are served in order.
+type tokenBucket struct {
+	mu     sync.Mutex
+	limit  float64 // configured requests per second
+	rate   float64 // current requests per second, lowered on 429
+	burst  float64
+	tokens float64
+	last   time.Time
+
+	first     time.Time // time of the first request
+	requests  int
+	throttled int
+}
+
+// newTokenBucket allows one request per interval, with a burst of up to one
+// second's worth of requests
+func newTokenBucket(interval time.Duration) *tokenBucket {
+	limit := float64(time.Second) / float64(interval)
+	burst := limit
+	if burst < 1 {
+		burst = 1
+	}
+	return &tokenBucket{limit: limit, rate: limit, burst: burst, tokens: 1, last: time.Now()}
+}
+
+// refill adds the tokens earned since the last call; the caller holds mu
+func (b *tokenBucket) refill(now time.Time) {
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+}
+
+// Wait blocks until the caller may send its next request
+func (b *tokenBucket) Wait() {
+	b.mu.Lock()
+	now := time.Now()
+	b.refill(now)
+	b.tokens--
+	var wait time.Duration
+	if b.tokens < 0 {
+		wait = time.Duration(-b.tokens / b.rate * float64(time.Second))
+	}
+	if b.first.IsZero() {
+		b.first = now
+	}
+	b.requests++
+	b.mu.Unlock()
+
+	time.Sleep(wait)
+}
+
+// Throttle halves the rate after the endpoint rate limited a request and
+// drops any saved-up burst. Returns the new rate.
+func (b *tokenBucket) Throttle() float64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill(time.Now())
+	b.throttled++
+	b.rate /= 2
+	if floor := b.limit * minRateFraction; b.rate < floor {
+		b.rate = floor
+	}
+	if b.tokens > 0 {
+		b.tokens = 0
+	}
+	return b.rate
+}
+
+// Recover raises a throttled rate back towards the configured one after a
+// successful request
+func (b *tokenBucket) Recover() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.rate >= b.limit {
+		return
+	}
+	b.refill(time.Now())
+	b.rate += b.limit * rateRecoveryStep
+	if b.rate > b.limit {
+		b.rate = b.limit
+	}
+}
+
+// RateStats summarizes the requests sent to one endpoint host
+type RateStats struct {
+	Host      string
+	Requests  int
+	Throttled int     // 429 responses
+	Limit     float64 // configured requests per second
+	Rate      float64 // current requests per second
+	Effective float64 // requests per second actually sent
+}
+
+func (b *tokenBucket) stats(host string) RateStats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	stats := RateStats{Host: host, Requests: b.requests, Throttled: b.throttled, Limit: b.limit, Rate: b.rate}
+	if elapsed := time.Since(b.first).Seconds(); b.requests > 0 && elapsed > 0 {
+		stats.Effective = float64(b.requests) / elapsed
+	}
+	return stats
+}
+
+// bucket returns the limiter of rawURL's host, or nil when requests aren't
+// limited
+func (c *APIClient) bucket(rawURL string) *tokenBucket {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.rateLimit <= 0 {
+		return nil
+	}
+	host := endpointHost(rawURL)
+	b, ok := c.buckets[host]
+	if !ok {
+		if c.buckets == nil {
+			c.buckets = make(map[string]*tokenBucket)
+		}
+		b = newTokenBucket(c.rateLimit)
+		c.buckets[host] = b
+	}
+	return b
+}
+
+// Throttle slows down requests to rawURL's host after it rate limited one
+func (c *APIClient) Throttle(rawURL string) {
+	metricRateLimitBackoffs.Inc(endpointHost(rawURL))
+	if b := c.bucket(rawURL); b != nil {
+		rate := b.Throttle()
+		httpLogger.Warn("Host is rate limiting requests, slowing down", "host", endpointHost(rawURL), "rate", rate)
+	}
+}
+
+// endpointHost returns the host requests to rawURL are limited under
+func endpointHost(rawURL string) string {
+	if u, err := url.Parse(rawURL); err == nil && u.Host != "" {
+		return u.Host
+	}
+	return rawURL
+}
+
+// RateStats reports the requests the client sent to each host, sorted by
+// host
+func (c *APIClient) RateStats() []RateStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var stats []RateStats
+	for host, b := range c.buckets {
+		stats = append(stats, b.stats(host))
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Host < stats[j].Host })
+	return stats
+}
@@ -0,0 +1,234 @@
+package main
+
+// reorgWindow is how many of the most recently scanned blocks have their
+// hashes tracked. A reorg deeper than that is rolled back to the oldest
+// tracked block.
+const reorgWindow = 64
+
+// maxReorgRescans bounds how often a scan rescans reorged-out blocks before
+// it finishes
+const maxReorgRescans = 3
+
+// BlockRef is a scanned block's number and hash
+type BlockRef struct {
+	Number uint64 `json:"number"`
+	Hash   string `json:"hash"`
+}
+
+// confirmedHead returns the newest block with the given number of
+// confirmations
+func confirmedHead(latest uint64, confirmations int) uint64 {
+	if uint64(confirmations) >= latest {
+		return 0
+	}
+	return latest - uint64(confirmations)
+}
+
+// tracksReorgs reports whether the run checks for reorgs (see
+// startReorgTracking)
+func (s *scanSession) tracksReorgs() bool {
+	return s.reorgTracking
+}
+
+// startReorgTracking decides at the start of a run scanning through
+// endBlock whether it tracks reorgs. Only blocks near the chain head (latest)
+// can still be replaced, so it does in watch mode, when endBlock is within
+// reorgWindow of latest, and when resuming tracked blocks that still are. A
+// scan of older blocks skips the header lookups.
+func (s *scanSession) startReorgTracking(endBlock, latest uint64) {
+	blocks := s.progress.RecentBlocks
+	resumed := len(blocks) > 0 && blocks[len(blocks)-1].Number+reorgWindow > latest
+	s.reorgTracking = s.config.Watch || endBlock+reorgWindow > latest || resumed
+}
+
+// checkReorgs verifies that the tracked blocks are still on the canonical
+// chain and extends tracking through head, checking that each new block's
+// parent is the tracked one before it. If tracked blocks were replaced, the
+// scan is rolled back to the newest block both chains share, which is
+// returned.
+func (s *scanSession) checkReorgs(head uint64) (uint64, bool) {
+	if !s.tracksReorgs() {
+		return 0, false
+	}
+	s.mu.Lock()
+	blocks := append([]BlockRef{}, s.progress.RecentBlocks...)
+	s.mu.Unlock()
+
+	from := uint64(1)
+	if head > reorgWindow {
+		from = head - reorgWindow + 1
+	}
+	if len(blocks) > 0 {
+		// Replacing any tracked block changes the hash of every block after it
+		tip := blocks[len(blocks)-1]
+		header, err := s.source.BlockHeader(tip.Number)
+		if err != nil {
+			logger.Warn("Could not check block for reorgs", "block", tip.Number, "err", err)
+			return 0, false
+		}
+		if header.Hash != tip.Hash {
+			return s.rollBackReorg(blocks), true
+		}
+		if tip.Number >= from {
+			from = tip.Number + 1
+		}
+	}
+
+	for number := from; number <= head; number++ {
+		header, err := s.source.BlockHeader(number)
+		if err != nil {
+			logger.Warn("Could not get block for reorg tracking", "block", number, "err", err)
+			break
+		}
+		if last := len(blocks) - 1; last >= 0 && blocks[last].Number == number-1 && header.ParentHash != blocks[last].Hash {
+			// The chain changed since the tip was checked
+			return s.rollBackReorg(blocks), true
+		}
+		blocks = append(blocks, BlockRef{Number: number, Hash: header.Hash})
+	}
+	if len(blocks) > reorgWindow {
+		blocks = blocks[len(blocks)-reorgWindow:]
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.progress.RecentBlocks = blocks
+	// Findings older than the window can no longer be retracted
+	var recent []Finding
+	for _, finding := range s.progress.RecentFindings {
+		if len(blocks) > 0 && finding.BlockNumber >= blocks[0].Number {
+			recent = append(recent, finding)
+		}
+	}
+	s.progress.RecentFindings = recent
+	return 0, false
+}
+
+// rollBackReorg walks the tracked blocks down to the newest one still on
+// the canonical chain and rolls the scan back to it
+func (s *scanSession) rollBackReorg(blocks []BlockRef) uint64 {
+	kept := 0
+	for i := len(blocks) - 1; i >= 0; i-- {
+		header, err := s.source.BlockHeader(blocks[i].Number)
+		if err != nil {
+			// Treat the block as replaced; rescanning it is harmless
+			logger.Warn("Could not check block for reorgs", "block", blocks[i].Number, "err", err)
+			continue
+		}
+		if header.Hash == blocks[i].Hash {
+			kept = i + 1
+			break
+		}
+	}
+
+	fork := blocks[0].Number - 1
+	if kept > 0 {
+		fork = blocks[kept-1].Number
+	} else {
+		logger.Error("Reorg reaches below the tracked blocks", "tracked_blocks", len(blocks), "fork_block", fork)
+	}
+	logger.Warn("Reorg detected", "fork_block", fork)
+	progressf("\n🔀 Reorg on %s: blocks after %d were replaced, rescanning them\n", s.network.Name, fork)
+	s.rollBack(fork, blocks[:kept])
+	return fork
+}
+
+// rollBack undoes the scan's results above fork: findings there are
+// retracted in the output, and every address (and the watch head) resumes
+// from fork so the replacement blocks are scanned
+func (s *scanSession) rollBack(fork uint64, blocks []BlockRef) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.progress.RecentBlocks = blocks
+
+	var kept []Finding
+	for _, finding := range s.progress.RecentFindings {
+		if finding.BlockNumber <= fork {
+			kept = append(kept, finding)
+			continue
+		}
+		if s.writer.Retract(finding) {
+			detectorLogger.Info("Retracted finding", "rule", finding.Rule, "address", finding.Proxy,
+				"tx", finding.TxHash, "block", finding.BlockNumber)
+			progressf("↩️  Retracted %s finding: proxy %s, tx %s, block %d was reorged out\n",
+				finding.Rule, finding.Proxy, finding.TxHash, finding.BlockNumber)
+		} else {
+			detectorLogger.Warn("Reorged-out finding not found in the output, nothing retracted", "rule", finding.Rule,
+				"address", finding.Proxy, "tx", finding.TxHash, "block", finding.BlockNumber, "file", s.config.OutputFile)
+		}
+		s.uncountFinding(finding)
+		s.retracted++
+		metricFindingsRetracted.Inc(finding.Rule)
+	}
+	s.progress.RecentFindings = kept
+	if err := s.writer.Flush(); err != nil {
+		logger.Error("Failed to write retractions", "file", s.config.OutputFile, "err", err)
+	}
+
+	for address, info := range s.progress.Addresses {
+		if info.LastScannedBlock > fork {
+			// The address has blocks to scan again, so it isn't complete
+			info.LastScannedBlock = fork
+			info.SweepComplete = false
+			info.Processed = false
+		}
+		// An upgrade in a replaced block no longer precedes later transactions
+		if info.LastUpgradeBlock > fork {
+			info.LastUpgradeTx, info.LastUpgradeBlock = "", 0
+		}
+		info.FailedRanges = clipFailedRanges(info.FailedRanges, fork)
+		s.progress.Addresses[address] = info
+	}
+	if s.progress.HeadBlock > fork {
+		s.progress.HeadBlock = fork
+	}
+	for _, ctx := range s.proxyContexts {
+		if ctx.lastUpgradeBlock > fork {
+			ctx.lastUpgradeTx, ctx.lastUpgradeFrom, ctx.lastUpgradeBlock = "", "", 0
+		}
+	}
+
+	if s.store == nil {
+		if err := saveAddressProgress(s.progressFile, *s.progress); err != nil {
+			logger.Error("Failed to save progress", "err", err)
+		}
+		return
+	}
+	if err := s.store.RollBack(s.progress.ScanID, fork); err != nil {
+		logger.Error("Failed to roll back the store", "fork_block", fork, "err", err)
+	}
+	if err := s.store.SaveProgress(*s.progress); err != nil {
+		logger.Error("Failed to save progress", "err", err)
+	}
+}
+
+// uncountFinding reverses the counters addFinding bumped for a finding.
+// The caller must hold mu.
+func (s *scanSession) uncountFinding(finding Finding) {
+	if isDuplicateRule(finding.Rule) {
+		s.progress.DuplicateTxs--
+	}
+	if s.progress.RuleFindings[finding.Rule] > 0 {
+		s.progress.RuleFindings[finding.Rule]--
+	}
+	s.progress.ProcessedTxs--
+	if finding.Severity == SeverityHigh {
+		s.progress.HighSeverityTxs--
+	}
+}
+
+// clipFailedRanges drops the parts of coverage gaps above fork; those
+// blocks are rescanned anyway
+func clipFailedRanges(ranges []FailedRange, fork uint64) []FailedRange {
+	var clipped []FailedRange
+	for _, r := range ranges {
+		if r.FromBlock > fork {
+			continue
+		}
+		if r.ToBlock > fork {
+			r.ToBlock = fork
+		}
+		clipped = append(clipped, r)
+	}
+	return clipped
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RPCError is an error object returned by a JSON-RPC endpoint
+type RPCError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *RPCError) Error() string {
+	return fmt.Sprintf("JSON-RPC error %d: %s", e.Code, e.Message)
+}
+
+// rpcCall performs a single JSON-RPC 2.0 call and decodes its result
+func rpcCall(client *APIClient, url, method string, params []interface{}, result interface{}) error {
+	if params == nil {
+		params = []interface{}{}
+	}
+	request, err := json.Marshal(map[string]interface{}{
+		"jsonrpc": "2.0",
+		"id":      1,
+		"method":  method,
+		"params":  params,
+	})
+	if err != nil {
+		return err
+	}
+
+	body, err := client.Post(url, "application/json", request)
+	if err != nil {
+		return fmt.Errorf("failed to call %s: %w", method, err)
+	}
+
+	var rpcResp struct {
+		Result json.RawMessage `json:"result"`
+		Error  *RPCError       `json:"error"`
+	}
+	if err := decodeResponse(url, body, &rpcResp); err != nil {
+		return fmt.Errorf("failed to parse %s response: %w", method, err)
+	}
+	if rpcResp.Error != nil {
+		return rpcResp.Error
+	}
+	if err := decodeResponse(url, rpcResp.Result, result); err != nil {
+		return fmt.Errorf("failed to parse %s result: %w", method, err)
+	}
+	return nil
+}
+
+// parseHexUint64 parses a 0x-prefixed hex quantity
+func parseHexUint64(s string) (uint64, error) {
+	if !strings.HasPrefix(s, "0x") && !strings.HasPrefix(s, "0X") {
+		return 0, fmt.Errorf("invalid hex quantity %q", s)
+	}
+	return strconv.ParseUint(s[2:], 16, 64)
+}
+
+func toHexQuantity(n uint64) string {
+	return "0x" + strconv.FormatUint(n, 16)
+}
+
+// RPCSource reads chain data from a standard Ethereum JSON-RPC endpoint,
+// e.g. an archive node or an Anvil fork
+type RPCSource struct {
+	URL    string
+	Client *APIClient
+}
+
+func (r *RPCSource) Name() string { return BackendRPC }
+
+func (r *RPCSource) LatestBlockNumber() (uint64, error) {
+	var result string
+	if err := rpcCall(r.Client, r.URL, "eth_blockNumber", nil, &result); err != nil {
+		return 0, err
+	}
+	return parseHexUint64(result)
+}
+
+// FetchLogs matches every topic in one call: a list in the topic0 position
+// of the filter is an OR
+func (r *RPCSource) FetchLogs(topics []string, fromBlock, toBlock uint64, addresses []string) ([]LogEntry, error) {
+	filter := map[string]interface{}{
+		"fromBlock": toHexQuantity(fromBlock),
+		"toBlock":   toHexQuantity(toBlock),
+		"topics":    []interface{}{topics},
+	}
+	if len(addresses) > 0 {
+		filter["address"] = addresses
+	}
+
+	var logs []LogEntry
+	err := rpcCall(r.Client, r.URL, "eth_getLogs", []interface{}{filter}, &logs)
+	if rpcErr, ok := err.(*RPCError); ok && isLogLimitError(rpcErr) {
+		return nil, fmt.Errorf("%w: %s", ErrLogLimitExceeded, rpcErr.Message)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return logs, nil
+}
+
+// logLimitMessages are the errors nodes and providers return for eth_getLogs
+// queries with more results than they serve
+var logLimitMessages = []string{
+	"query returned more than",   // geth and nodes like it, e.g. "... 10000 results" (the cap varies)
+	"log response size exceeded", // Alchemy
+}
+
+// isLogLimitError recognizes eth_getLogs errors for too many results, which a
+// smaller range fixes. Other errors, including block range limits, are not
+// matched: bisecting can't help with those, so they become coverage gaps.
+func isLogLimitError(err *RPCError) bool {
+	message := strings.ToLower(err.Message)
+	for _, hint := range logLimitMessages {
+		if strings.Contains(message, hint) {
+			return true
+		}
+	}
+	return false
+}
+
+// LogsCap is 0: nodes reject oversized queries with an error instead of
+// truncating them
+func (r *RPCSource) LogsCap() int { return 0 }
+
+func (r *RPCSource) TransactionFrom(txHash string) (string, error) {
+	var tx *Transaction
+	if err := rpcCall(r.Client, r.URL, "eth_getTransactionByHash", []interface{}{txHash}, &tx); err != nil {
+		return "", err
+	}
+	if tx == nil {
+		return "", fmt.Errorf("transaction %s not found", txHash)
+	}
+	return tx.From, nil
+}
+
+func (r *RPCSource) code(address, block string) (string, error) {
+	var code string
+	err := rpcCall(r.Client, r.URL, "eth_getCode", []interface{}{address, block}, &code)
+	return code, err
+}
+
+func (r *RPCSource) StorageAt(address, slot string) (string, error) {
+	var word string
+	if err := rpcCall(r.Client, r.URL, "eth_getStorageAt", []interface{}{address, slot, "latest"}, &word); err != nil {
+		return "", err
+	}
+	return word, nil
+}
+
+func (r *RPCSource) Call(to, data string) (string, error) {
+	var result string
+	call := map[string]string{"to": to, "data": data}
+	if err := rpcCall(r.Client, r.URL, "eth_call", []interface{}{call, "latest"}, &result); err != nil {
+		return "", err
+	}
+	return result, nil
+}
+
+func (r *RPCSource) BlockTimestamp(block uint64) (time.Time, error) {
+	var header *rpcBlockHeader
+	if err := rpcCall(r.Client, r.URL, "eth_getBlockByNumber", []interface{}{toHexQuantity(block), false}, &header); err != nil {
+		return time.Time{}, err
+	}
+	return header.blockTime(block)
+}
+
+func (r *RPCSource) BlockHeader(block uint64) (BlockHeader, error) {
+	var header *rpcBlockHeader
+	if err := rpcCall(r.Client, r.URL, "eth_getBlockByNumber", []interface{}{toHexQuantity(block), false}, &header); err != nil {
+		return BlockHeader{}, err
+	}
+	return header.blockHeader(block)
+}
+
+// ContractCreation checks that the address has code and binary-searches
+// eth_getCode for the first block where it does. Plain JSON-RPC can't tell
+// whether a contract is a proxy or which transaction created it, so every
+// contract is accepted and the creation tx is left empty. Without archive
+// state the creation block is unknown (0).
+func (r *RPCSource) ContractCreation(address string) (uint64, string, error) {
+	code, err := r.code(address, "latest")
+	if err != nil {
+		return 0, "", fmt.Errorf("failed to fetch contract code: %w", err)
+	}
+	if code == "" || code == "0x" {
+		return 0, "", ErrNotContract
+	}
+
+	latest, err := r.LatestBlockNumber()
+	if err != nil {
+		return 0, "", fmt.Errorf("failed to get latest block: %w", err)
+	}
+
+	low, high := uint64(0), latest
+	for low < high {
+		mid := low + (high-low)/2
+		code, err := r.code(address, toHexQuantity(mid))
+		if err != nil {
+			httpLogger.Debug("Historical eth_getCode unavailable (not an archive node?)", "address", address, "block", mid, "err", err)
+			return 0, "", nil
+		}
+		if code == "" || code == "0x" {
+			low = mid + 1
+		} else {
+			high = mid
+		}
+	}
+
+	return low, "", nil
+}
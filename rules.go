@@ -0,0 +1,316 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Topics of the proxy lifecycle events watched alongside Upgraded(address)
+var (
+	AdminChangedEventTopic   = registryTopic("AdminChanged(address,address)")
+	BeaconUpgradedEventTopic = registryTopic("BeaconUpgraded(address)")
+	// OpenZeppelin Initializable up to v4, and v5
+	InitializedEventTopic   = registryTopic("Initialized(uint8)")
+	InitializedV5EventTopic = registryTopic("Initialized(uint64)")
+)
+
+// Event kinds the rules match on
+const (
+	EventUpgraded       = "Upgraded"
+	EventAdminChanged   = "AdminChanged"
+	EventBeaconUpgraded = "BeaconUpgraded"
+	EventInitialized    = "Initialized"
+)
+
+// SeverityLow is used for patterns that are also produced by some benign
+// deployments and need manual review
+const SeverityLow = "low"
+
+// txEvents is the logs one proxy emitted in one transaction, in log order
+type txEvents struct {
+	TxHash string
+	Proxy  string
+	Block  string
+	Logs   []LogEntry
+
+	// Sender of the transaction, resolved before matching when a rule
+	// compares senders ("" = not resolved)
+	From string
+
+	byKind map[string][]LogEntry
+}
+
+// Count returns the number of events of a kind in the transaction
+func (t *txEvents) Count(kind string) int {
+	return len(t.byKind[kind])
+}
+
+// firstIndex returns the lowest log index in the transaction
+func (t *txEvents) firstIndex() uint64 {
+	if len(t.Logs) == 0 {
+		return 0
+	}
+	index, _ := t.Logs[0].Index()
+	return index
+}
+
+// ruleContext is the state a rule can consult besides the transaction itself
+type ruleContext struct {
+	info ContractInfo
+
+	// Most recent earlier transaction that upgraded the proxy ("" = none
+	// seen), its sender when a rule compares senders, and its block (for
+	// reorg rollback)
+	lastUpgradeTx    string
+	lastUpgradeFrom  string
+	lastUpgradeBlock uint64
+}
+
+// Rule is a named per-transaction detection pattern
+type Rule struct {
+	Name        string
+	Description string
+	Severity    string
+	// Event kinds the rule needs fetched
+	Events []string
+
+	// CountsDuplicates marks the rule whose findings are the duplicate
+	// Upgraded transactions counted in the scan summary
+	CountsDuplicates bool
+
+	// NeedsCreationTx marks a rule that can only match the proxy's creation
+	// transaction; it is disabled on backends that can't tell it
+	NeedsCreationTx bool
+
+	// SenderEvents are the event kinds whose transactions' senders the rule
+	// compares. They are resolved into txEvents.From (and the last upgrade's
+	// into ruleContext.lastUpgradeFrom) before Match runs.
+	SenderEvents []string
+
+	Match func(ctx *ruleContext, tx *txEvents) bool
+}
+
+// Rules lists every detection rule in evaluation order
+var Rules = []Rule{
+	{
+		Name:             "upgraded-multiple",
+		Description:      "Upgraded emitted 2+ times in one transaction",
+		Severity:         SeverityMedium,
+		Events:           []string{EventUpgraded},
+		CountsDuplicates: true,
+		Match: func(ctx *ruleContext, tx *txEvents) bool {
+			return tx.Count(EventUpgraded) >= 2
+		},
+	},
+	{
+		Name:            "admin-upgrade-in-creation",
+		Description:     "AdminChanged and Upgraded in the proxy's creation transaction",
+		Severity:        SeverityLow,
+		Events:          []string{EventUpgraded, EventAdminChanged},
+		NeedsCreationTx: true,
+		Match: func(ctx *ruleContext, tx *txEvents) bool {
+			return ctx.info.CreationTx != "" && strings.EqualFold(tx.TxHash, ctx.info.CreationTx) &&
+				tx.Count(EventAdminChanged) >= 1 && tx.Count(EventUpgraded) >= 1
+		},
+	},
+	{
+		Name:        "beacon-upgraded-multiple",
+		Description: "BeaconUpgraded emitted 2+ times in one transaction",
+		Severity:    SeverityMedium,
+		Events:      []string{EventBeaconUpgraded},
+		Match: func(ctx *ruleContext, tx *txEvents) bool {
+			return tx.Count(EventBeaconUpgraded) >= 2
+		},
+	},
+	{
+		Name:        "initialized-repeated",
+		Description: "Initialized emitted 2+ times in one transaction",
+		Severity:    SeverityMedium,
+		Events:      []string{EventInitialized},
+		Match: func(ctx *ruleContext, tx *txEvents) bool {
+			return tx.Count(EventInitialized) >= 2
+		},
+	},
+	{
+		Name:         "initialized-after-upgrade-by-other",
+		Description:  "Initialized in a later transaction than the last Upgraded, sent by a different account",
+		Severity:     SeverityMedium,
+		Events:       []string{EventUpgraded, EventInitialized},
+		SenderEvents: []string{EventUpgraded, EventInitialized},
+		Match: func(ctx *ruleContext, tx *txEvents) bool {
+			if tx.Count(EventInitialized) == 0 || ctx.lastUpgradeTx == "" || ctx.lastUpgradeTx == tx.TxHash {
+				return false
+			}
+			if ctx.lastUpgradeFrom == "" || tx.From == "" {
+				return false
+			}
+			return !strings.EqualFold(ctx.lastUpgradeFrom, tx.From)
+		},
+	},
+}
+
+// findRule returns the rule with the given name
+func findRule(name string) (Rule, bool) {
+	for _, rule := range Rules {
+		if rule.Name == name {
+			return rule, true
+		}
+	}
+	return Rule{}, false
+}
+
+// RuleNames returns the names of every rule
+func RuleNames() []string {
+	names := make([]string, len(Rules))
+	for i, rule := range Rules {
+		names[i] = rule.Name
+	}
+	return names
+}
+
+// enabledRules returns the rules selected by the config (all when none are named)
+func enabledRules(config ScannerConfig) []Rule {
+	if len(config.Rules) == 0 {
+		return Rules
+	}
+	var rules []Rule
+	for _, rule := range Rules {
+		for _, name := range config.Rules {
+			if rule.Name == name {
+				rules = append(rules, rule)
+				break
+			}
+		}
+	}
+	return rules
+}
+
+// backendRules drops the rules the backend can't evaluate: on the rpc
+// backend, which can't tell a proxy's creation transaction, those that need it
+func backendRules(rules []Rule, backend string) []Rule {
+	if backend != BackendRPC {
+		return rules
+	}
+	var kept []Rule
+	for _, rule := range rules {
+		if rule.NeedsCreationTx {
+			logger.Warn("Rule disabled: the backend can't tell a proxy's creation transaction", "rule", rule.Name, "backend", backend)
+			continue
+		}
+		kept = append(kept, rule)
+	}
+	return kept
+}
+
+// isDuplicateRule reports whether findings of the named rule count as
+// duplicate Upgraded transactions
+func isDuplicateRule(name string) bool {
+	rule, ok := findRule(name)
+	return ok && rule.CountsDuplicates
+}
+
+// ListRules prints every detection rule with the events it subscribes to
+func ListRules() {
+	for _, rule := range Rules {
+		fmt.Printf("%s:\n", rule.Name)
+		fmt.Printf("  %s\n", rule.Description)
+		fmt.Printf("  Severity: %s\n", rule.Severity)
+		fmt.Printf("  Events: %s\n", strings.Join(rule.Events, ", "))
+		fmt.Println()
+	}
+}
+
+// validateRules checks that every named rule exists
+func validateRules(names []string) error {
+	for _, name := range names {
+		if _, ok := findRule(name); !ok {
+			return fmt.Errorf("unknown rule %q (available: %s)", name, strings.Join(RuleNames(), ", "))
+		}
+	}
+	return nil
+}
+
+// eventTopics returns the topic0 of each event kind. Upgraded uses the
+// configured event topic.
+func eventTopics(config ScannerConfig) map[string][]string {
+	return map[string][]string{
+		EventUpgraded:       {config.EventTopic},
+		EventAdminChanged:   {AdminChangedEventTopic},
+		EventBeaconUpgraded: {BeaconUpgradedEventTopic},
+		EventInitialized:    {InitializedEventTopic, InitializedV5EventTopic},
+	}
+}
+
+// subscribedTopics returns the topics the enabled rules need, and the event
+// kind of each topic
+func subscribedTopics(config ScannerConfig, rules []Rule) ([]string, map[string]string) {
+	topicsByKind := eventTopics(config)
+	kinds := make(map[string]string)
+	var topics []string
+	for _, rule := range rules {
+		for _, kind := range rule.Events {
+			for _, topic := range topicsByKind[kind] {
+				topic = strings.ToLower(topic)
+				if _, ok := kinds[topic]; !ok {
+					kinds[topic] = kind
+					topics = append(topics, topic)
+				}
+			}
+		}
+	}
+	return topics, kinds
+}
+
+// groupTransactions groups logs by transaction and emitting proxy, ordered by
+// block and log index so rules that depend on earlier transactions see them
+// first
+func groupTransactions(logs []LogEntry, kinds map[string]string) []*txEvents {
+	byKey := make(map[string]*txEvents)
+	var txs []*txEvents
+	for _, logEntry := range logs {
+		key := logEntry.TransactionHash + "/" + strings.ToLower(logEntry.Address)
+		tx, ok := byKey[key]
+		if !ok {
+			tx = &txEvents{
+				TxHash: logEntry.TransactionHash,
+				Proxy:  logEntry.Address,
+				Block:  logEntry.BlockNumber,
+				byKind: make(map[string][]LogEntry),
+			}
+			byKey[key] = tx
+			txs = append(txs, tx)
+		}
+		tx.Logs = append(tx.Logs, logEntry)
+		if len(logEntry.Topics) > 0 {
+			kind := kinds[strings.ToLower(logEntry.Topics[0])]
+			tx.byKind[kind] = append(tx.byKind[kind], logEntry)
+		}
+	}
+
+	for _, tx := range txs {
+		sort.SliceStable(tx.Logs, func(i, j int) bool {
+			a, _ := tx.Logs[i].Index()
+			b, _ := tx.Logs[j].Index()
+			return a < b
+		})
+	}
+	sort.SliceStable(txs, func(i, j int) bool {
+		a, _ := blockNumberOf(txs[i].Block)
+		b, _ := blockNumberOf(txs[j].Block)
+		if a != b {
+			return a < b
+		}
+		return txs[i].firstIndex() < txs[j].firstIndex()
+	})
+	return txs
+}
+
+// blockNumberOf parses a block number given in hex (0x...) or decimal
+func blockNumberOf(s string) (uint64, error) {
+	if strings.HasPrefix(s, "0x") {
+		return parseHexUint64(s)
+	}
+	return strconv.ParseUint(s, 10, 64)
+}
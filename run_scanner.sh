@@ -15,16 +15,23 @@ echo "📝 Logs will be written to: $LOG_FILE"
 screen -S $SCREEN_NAME -X quit 2>/dev/null
 
 # Set log level (INFO for normal operation, DEBUG for troubleshooting, ERROR for minimal output)
+# Per-component levels can follow, e.g. LOG_LEVEL=INFO,http=DEBUG (see LOGGING.md)
 export LOG_LEVEL=${LOG_LEVEL:-INFO}
 
+# Log format: text, or json for log shipping
+export LOG_FORMAT=${LOG_FORMAT:-text}
+
+# Extra flags passed to "cpimp scan" (e.g. SCAN_ARGS="-network ethereum -address-file eco_projects.txt")
+export SCAN_ARGS=${SCAN_ARGS:-}
+
 # Start scanner in screen session with logging
 screen -S $SCREEN_NAME -dm bash -c "
     echo '🚀 Scanner started at: $(date) with LOG_LEVEL=$LOG_LEVEL' | tee -a $LOG_FILE
-    LOG_LEVEL=$LOG_LEVEL go run main.go config.go network_configs.go 2>&1 | tee -a $LOG_FILE
+    LOG_LEVEL=$LOG_LEVEL LOG_FORMAT=$LOG_FORMAT go run . scan $SCAN_ARGS 2>&1 | tee -a $LOG_FILE
 "
 
 echo "✅ Scanner started in screen session: $SCREEN_NAME"
-echo "📊 Log level: $LOG_LEVEL"
+echo "📊 Log level: $LOG_LEVEL (format: $LOG_FORMAT)"
 echo ""
 echo "📋 Useful commands:"
 echo "  View logs:      tail -f $LOG_FILE"
@@ -38,4 +45,6 @@ echo ""
 echo "🎛️  Log level control:"
 echo "  Minimal output: LOG_LEVEL=ERROR ./run_scanner.sh"
 echo "  Normal output:  LOG_LEVEL=INFO ./run_scanner.sh"
-echo "  Debug output:   LOG_LEVEL=DEBUG ./run_scanner.sh" 
\ No newline at end of file
+echo "  Debug output:   LOG_LEVEL=DEBUG ./run_scanner.sh"
+echo "  Debug API only: LOG_LEVEL=INFO,http=DEBUG ./run_scanner.sh"
+echo "  JSON logs:      LOG_FORMAT=json ./run_scanner.sh" 
\ No newline at end of file
@@ -2,18 +2,22 @@ package main
 
 import (
 	"fmt"
-	"log"
 	"os"
 	"path/filepath"
 	"strings"
 	"time"
 )
 
-// ListActiveScans shows all ongoing scans
-func ListActiveScans() {
+// ListActiveScans shows all ongoing scans, from the store when one is given
+func ListActiveScans(store *Store) {
+	if store != nil {
+		listStoredScans(store)
+		return
+	}
+
 	files, err := filepath.Glob("scan_progress_*.json")
 	if err != nil {
-		log.Printf("Error listing scan files: %v", err)
+		logger.Error("Failed to list scan files", "err", err)
 		return
 	}
 
@@ -25,43 +29,86 @@ func ListActiveScans() {
 	fmt.Printf("Found %d active scan(s):\n\n", len(files))
 
 	for _, file := range files {
-		progress := loadAddressProgress(file)
+		progress, err := loadAddressProgress(file)
+		if err != nil {
+			logger.Error("Failed to load scan progress", "file", file, "err", err)
+			continue
+		}
 		if progress.ScanID == "" {
 			continue
 		}
+		printScanSummary(progress, "Progress File: "+file)
+	}
+}
 
-		fmt.Printf("Scan ID: %s\n", progress.ScanID)
-		fmt.Printf("  Network: %s\n", progress.Network)
-		fmt.Printf("  Event Topic: %s\n", progress.EventTopic)
-		fmt.Printf("  Target Addresses: %d\n", len(progress.Addresses))
-		fmt.Printf("  Total Logs Found: %d\n", progress.TotalLogs)
-		fmt.Printf("  Duplicate Transactions: %d\n", progress.DuplicateTxs)
-		fmt.Printf("  Processed Transactions: %d\n", progress.ProcessedTxs)
-		fmt.Printf("  Last Updated: %s\n", progress.LastUpdated.Format("2006-01-02 15:04:05"))
-		fmt.Printf("  Progress File: %s\n", file)
-
-		// Show individual address status
-		if len(progress.Addresses) > 0 {
-			fmt.Printf("  Address Status:\n")
-			for addr, info := range progress.Addresses {
-				status := "pending"
-				if info.Processed {
-					status = "completed"
-				}
-				fmt.Printf("    %s: %s (creation block: %d)\n", addr, status, info.CreationBlock)
-			}
+// listStoredScans shows the unfinished scans of a store
+func listStoredScans(store *Store) {
+	ids, err := store.ActiveScans()
+	if err != nil {
+		logger.Error("Failed to list scans", "store", store.path, "err", err)
+		return
+	}
+
+	if len(ids) == 0 {
+		fmt.Println("No active scans found.")
+		return
+	}
+
+	fmt.Printf("Found %d active scan(s):\n\n", len(ids))
+
+	for _, id := range ids {
+		progress, err := store.LoadProgress(id)
+		if err != nil {
+			logger.Error("Failed to load scan", "scan_id", id, "err", err)
+			continue
 		}
-		fmt.Println()
+		printScanSummary(progress, "Store: "+store.path)
 	}
 }
 
+// printScanSummary prints a scan's entry in the scan list
+func printScanSummary(progress AddressProgress, location string) {
+	fmt.Printf("Scan ID: %s\n", progress.ScanID)
+	fmt.Printf("  Network: %s\n", progress.Network)
+	fmt.Printf("  Event Topic: %s\n", progress.EventTopic)
+	fmt.Printf("  Target Addresses: %d (%d scanned)\n", len(progress.Addresses), countScannable(progress))
+	fmt.Printf("  Total Logs Found: %d\n", progress.TotalLogs)
+	fmt.Printf("  Duplicate Transactions: %d\n", progress.DuplicateTxs)
+	fmt.Printf("  Processed Transactions: %d\n", progress.ProcessedTxs)
+	fmt.Printf("  Last Updated: %s\n", progress.LastUpdated.Format("2006-01-02 15:04:05"))
+	if progress.HeadBlock > 0 {
+		fmt.Printf("  Watched Through Block: %d\n", progress.HeadBlock)
+	}
+	fmt.Printf("  %s\n", location)
+
+	if gaps := countCoverageGaps(progress); gaps > 0 {
+		fmt.Printf("  Coverage Gaps: %d\n", gaps)
+	}
+
+	// Show individual address status
+	if len(progress.Addresses) > 0 {
+		fmt.Printf("  Address Status:\n")
+		for addr, info := range progress.Addresses {
+			fmt.Printf("    %s: %s (creation block: %d)\n", addr, addressStatus(info), info.CreationBlock)
+		}
+	}
+	fmt.Println()
+}
+
 // CleanupOldScans removes progress files older than specified duration
-func CleanupOldScans(olderThan time.Duration) {
+func CleanupOldScans(olderThan time.Duration, store *Store) {
+	if store != nil {
+		cleanupStoredScans(olderThan, store)
+		return
+	}
+
 	files, err := filepath.Glob("scan_progress_*.json")
 	if err != nil {
-		log.Printf("Error listing scan files: %v", err)
+		logger.Error("Failed to list scan files", "err", err)
 		return
 	}
+	completedFiles, _ := filepath.Glob("scan_completed_*.json")
+	files = append(files, completedFiles...)
 
 	if len(files) == 0 {
 		fmt.Println("No scan progress files found.")
@@ -78,9 +125,9 @@ func CleanupOldScans(olderThan time.Duration) {
 		}
 
 		if fileInfo.ModTime().Before(cutoff) {
-			err = os.Remove(file)
+			err = removeProgressFile(file)
 			if err != nil {
-				log.Printf("Error removing old scan file %s: %v", file, err)
+				logger.Error("Failed to remove old scan file", "file", file, "err", err)
 			} else {
 				fmt.Printf("Removed old scan progress file: %s\n", file)
 				cleaned++
@@ -95,28 +142,79 @@ func CleanupOldScans(olderThan time.Duration) {
 	}
 }
 
-// DeleteScan removes a specific scan by ID
-func DeleteScan(scanID string) {
-	progressFile := getProgressFileName(scanID)
-
-	if _, err := os.Stat(progressFile); os.IsNotExist(err) {
-		fmt.Printf("Scan ID %s not found.\n", scanID)
+// cleanupStoredScans removes the unfinished scans in the store that weren't
+// updated within olderThan. Completed scans are kept as history.
+func cleanupStoredScans(olderThan time.Duration, store *Store) {
+	ids, err := store.StaleScans(time.Now().Add(-olderThan))
+	if err != nil {
+		logger.Error("Failed to list scans", "store", store.path, "err", err)
 		return
 	}
 
-	err := os.Remove(progressFile)
-	if err != nil {
-		log.Printf("Error removing scan %s: %v", scanID, err)
-		return
+	cleaned := 0
+	for _, id := range ids {
+		if _, err := store.DeleteScan(id); err != nil {
+			logger.Error("Failed to remove old scan", "scan_id", id, "err", err)
+			continue
+		}
+		fmt.Printf("Removed old scan: %s\n", id)
+		cleaned++
 	}
 
-	fmt.Printf("Removed scan %s (file: %s)\n", scanID, progressFile)
+	if cleaned == 0 {
+		fmt.Printf("No scans older than %v found.\n", olderThan)
+	} else {
+		fmt.Printf("Cleaned up %d old scan(s).\n", cleaned)
+	}
+}
+
+// DeleteScan removes a specific scan by ID: its progress file, and its rows
+// when a store is given
+func DeleteScan(scanID string, store *Store) {
+	removed := false
+	if store != nil {
+		found, err := store.DeleteScan(scanID)
+		if err != nil {
+			logger.Error("Failed to remove scan", "scan_id", scanID, "store", store.path, "err", err)
+			return
+		}
+		if found {
+			fmt.Printf("Removed scan %s (store: %s)\n", scanID, store.path)
+			removed = true
+		}
+	}
+
+	for _, progressFile := range []string{getProgressFileName(scanID), getCompletedProgressFileName(scanID)} {
+		if _, err := os.Stat(progressFile); err != nil {
+			continue
+		}
+		if err := removeProgressFile(progressFile); err != nil {
+			logger.Error("Failed to remove scan", "scan_id", scanID, "err", err)
+			return
+		}
+		fmt.Printf("Removed scan %s (file: %s)\n", scanID, progressFile)
+		removed = true
+	}
+
+	if !removed {
+		fmt.Printf("Scan ID %s not found.\n", scanID)
+	}
 }
 
 // ShowScanDetails displays detailed information about a specific scan
-func ShowScanDetails(scanID string) {
+func ShowScanDetails(scanID string, store *Store) {
 	progressFile := getProgressFileName(scanID)
-	progress := loadAddressProgress(progressFile)
+	progress, err := loadScanProgress(store, scanID)
+	completed := false
+	if err == nil && progress.ScanID == "" {
+		progressFile = getCompletedProgressFileName(scanID)
+		progress, err = loadCompletedScan(store, scanID)
+		completed = true
+	}
+	if err != nil {
+		logger.Error("Failed to load scan", "scan_id", scanID, "err", err)
+		return
+	}
 
 	if progress.ScanID == "" {
 		fmt.Printf("Scan ID %s not found.\n", scanID)
@@ -127,44 +225,280 @@ func ShowScanDetails(scanID string) {
 	fmt.Printf("Scan ID: %s\n", progress.ScanID)
 	fmt.Printf("Network: %s\n", progress.Network)
 	fmt.Printf("Event Topic: %s\n", progress.EventTopic)
-	fmt.Printf("Target Addresses: %d\n", len(progress.Addresses))
+	if completed {
+		fmt.Printf("Status: completed\n")
+	}
+	fmt.Printf("Target Addresses: %d (%d scanned)\n", len(progress.Addresses), countScannable(progress))
 	fmt.Printf("Total Logs Found: %d\n", progress.TotalLogs)
 	fmt.Printf("Duplicate Transactions: %d\n", progress.DuplicateTxs)
 	fmt.Printf("Processed Transactions: %d\n", progress.ProcessedTxs)
+	fmt.Printf("High Severity Findings: %d\n", progress.HighSeverityTxs)
+	for _, rule := range Rules {
+		if count := progress.RuleFindings[rule.Name]; count > 0 {
+			fmt.Printf("  %s: %d\n", rule.Name, count)
+		}
+	}
 	fmt.Printf("Last Updated: %s\n", progress.LastUpdated.Format("2006-01-02 15:04:05 MST"))
-	fmt.Printf("Progress File: %s\n", progressFile)
+	if progress.HeadBlock > 0 {
+		fmt.Printf("Watched Through Block: %d\n", progress.HeadBlock)
+	}
+	if blocks := progress.RecentBlocks; len(blocks) > 0 {
+		fmt.Printf("Reorg Tracking: blocks %d-%d, %d finding(s) not yet final\n",
+			blocks[0].Number, blocks[len(blocks)-1].Number, len(progress.RecentFindings))
+	}
+	if store != nil {
+		fmt.Printf("Store: %s\n", store.path)
+	} else {
+		fmt.Printf("Progress File: %s\n", progressFile)
+	}
+
+	stats := progress.RangeStats
+	if stats.Requests > 0 {
+		fmt.Printf("Effective Block Range: min %d, avg %d, max %d (%d requests, %d splits)\n",
+			stats.MinRange, stats.AverageRange(), stats.MaxRange, stats.Requests, stats.Splits)
+		if stats.TruncatedPages > 0 {
+			fmt.Printf("Truncated Pages: %d (single blocks at the %d result cap)\n", stats.TruncatedPages, BlockscoutLogsCap)
+		}
+	}
 
 	// Show individual address details
 	if len(progress.Addresses) > 0 {
+		fmt.Println()
+		printClassificationBreakdown(os.Stdout, progress, "")
 		fmt.Printf("\nAddress Details:\n")
 		for addr, info := range progress.Addresses {
-			status := "pending"
-			if info.Processed {
-				status = "completed"
-			}
 			fmt.Printf("  %s:\n", addr)
-			fmt.Printf("    Status: %s\n", status)
+			fmt.Printf("    Status: %s\n", addressStatus(info))
 			fmt.Printf("    Creation Block: %d\n", info.CreationBlock)
 			fmt.Printf("    Creation Tx: %s\n", info.CreationTx)
+			if info.LastScannedBlock > 0 {
+				fmt.Printf("    Last Scanned Block: %d\n", info.LastScannedBlock)
+			}
+		}
+	}
+
+	// Show coverage gaps
+	if gaps := countCoverageGaps(progress); gaps > 0 {
+		fmt.Printf("\nCoverage Gaps: %d\n", gaps)
+		for addr, info := range progress.Addresses {
+			for _, gap := range info.FailedRanges {
+				fmt.Printf("  %s: blocks %d-%d (%d attempts, last %s): %s\n",
+					addr, gap.FromBlock, gap.ToBlock, gap.Attempts,
+					gap.LastAttempt.Format("2006-01-02 15:04:05"), gap.LastError)
+			}
+		}
+		if store != nil {
+			fmt.Printf("Retry with: cpimp scan retry-gaps -store %s %s\n", store.path, progress.ScanID)
+		} else {
+			fmt.Printf("Retry with: cpimp scan retry-gaps %s\n", progress.ScanID)
 		}
 	}
 }
 
-// Helper function to get scan ID from partial ID
-func findScanByPartialID(partialID string) string {
-	files, err := filepath.Glob("scan_progress_*.json")
+// addressStatus describes the scan state of an address
+func addressStatus(info ContractInfo) string {
+	switch {
+	case info.classification() == StatusAPIError:
+		return fmt.Sprintf("lookup failed, retried on resume (%s)", info.StatusError)
+	case !info.Scannable():
+		return "skipped (" + info.Status + ")"
+	case info.Processed:
+		return "completed"
+	case len(info.FailedRanges) > 0:
+		return fmt.Sprintf("incomplete (%d coverage gaps)", len(info.FailedRanges))
+	default:
+		return "pending"
+	}
+}
+
+// RetryScanGaps retries every recorded coverage gap of a scan, appending any
+// findings to the scan's output file, and completes the scan once nothing is
+// left to do. Returns the number of gaps still open.
+func RetryScanGaps(scanID, outputFile string, rateLimit time.Duration, rpcURL, apiKey string, store *Store) (int, error) {
+	progressFile := getProgressFileName(scanID)
+	progress, err := loadScanProgress(store, scanID)
 	if err != nil {
-		return ""
+		return 0, err
+	}
+	if progress.ScanID == "" {
+		return 0, fmt.Errorf("scan ID %s not found", scanID)
 	}
 
-	for _, file := range files {
-		// Extract scan ID from filename
-		filename := filepath.Base(file)
-		if strings.HasPrefix(filename, "scan_progress_") && strings.HasSuffix(filename, ".json") {
-			scanID := filename[14 : len(filename)-5] // Remove "scan_progress_" and ".json"
-			if strings.HasPrefix(scanID, partialID) {
-				return scanID
-			}
+	network, exists := Networks[progress.Network]
+	if !exists {
+		return 0, fmt.Errorf("unknown network: %s", progress.Network)
+	}
+	setLogScan(progress.ScanID, progress.Network)
+
+	if outputFile == "" {
+		outputFile = progress.OutputFile
+	}
+	if outputFile == "" {
+		return 0, fmt.Errorf("scan %s has no recorded output file; pass -output", scanID)
+	}
+	if rateLimit == 0 {
+		rateLimit = network.RateLimit()
+	}
+
+	gaps := countCoverageGaps(progress)
+	if gaps == 0 {
+		progressf("Scan %s has no coverage gaps.\n", scanID)
+		return 0, nil
+	}
+	progressf("Retrying %d coverage gap(s) for scan %s on %s\n", gaps, scanID, network.Name)
+
+	// Scans from before output formats were added wrote CSV
+	writer, err := openFindingsWriter(outputFile, progress.OutputFormat)
+	if err != nil {
+		return gaps, fmt.Errorf("failed to open output file: %v", err)
+	}
+	defer writer.Close()
+
+	// Retry against the backend the scan used
+	config := ScannerConfig{
+		Network:      progress.Network,
+		EventTopic:   progress.EventTopic,
+		Rules:        progress.Rules,
+		RateLimit:    rateLimit,
+		OutputFile:   outputFile,
+		OutputFormat: progress.OutputFormat,
+		Backend:      progress.Backend,
+		RPCURL:       rpcURL,
+		APIKey:       apiKey,
+	}
+	client := NewAPIClient(config.RateLimit)
+	source, err := newChainSource(config, network, client)
+	if err != nil {
+		return gaps, err
+	}
+	session := newScanSession(config, network, client, source, &progress, progressFile, writer, store)
+
+	remaining := 0
+	for addr := range progress.Addresses {
+		remaining += session.retryFailedRanges(addr)
+	}
+
+	progressf("%d of %d coverage gap(s) recovered, results appended to %s\n", gaps-remaining, gaps, outputFile)
+
+	// A watched scan is never complete; its progress holds the head cursor
+	if remaining == 0 && progress.HeadBlock == 0 && scanComplete(progress) {
+		completeScan(progress, progressFile, store)
+	}
+	return remaining, nil
+}
+
+// ReclassifyScan looks up the scan's addresses with the given
+// classifications again (every skipped address when none are given); the
+// ones now found valid are scanned when the scan is resumed. A completed
+// scan is reopened when that leaves it with addresses to scan or look up.
+// Returns the number of addresses that became valid.
+func ReclassifyScan(scanID string, statuses []string, rateLimit time.Duration, rpcURL, apiKey string, store *Store) (int, error) {
+	progressFile := getProgressFileName(scanID)
+	progress, err := loadScanProgress(store, scanID)
+	if err != nil {
+		return 0, err
+	}
+	completed := false
+	if progress.ScanID == "" {
+		if progress, err = loadCompletedScan(store, scanID); err != nil {
+			return 0, err
+		}
+		completed = true
+	}
+	if progress.ScanID == "" {
+		return 0, fmt.Errorf("scan ID %s not found", scanID)
+	}
+
+	network, exists := Networks[progress.Network]
+	if !exists {
+		return 0, fmt.Errorf("unknown network: %s", progress.Network)
+	}
+	setLogScan(progress.ScanID, progress.Network)
+	if rateLimit == 0 {
+		rateLimit = network.RateLimit()
+	}
+
+	if len(statuses) == 0 {
+		statuses = addressStatuses[1:] // everything but valid
+	}
+	var addresses []string
+	for _, status := range statuses {
+		addresses = append(addresses, addressesWithStatus(progress, status)...)
+	}
+	if len(addresses) == 0 {
+		progressf("Scan %s has no %s addresses.\n", scanID, strings.Join(statuses, "/"))
+		return 0, nil
+	}
+	progressf("Reclassifying %d address(es) of scan %s on %s\n", len(addresses), scanID, network.Name)
+
+	// Look up against the backend the scan used
+	config := ScannerConfig{
+		Network:   progress.Network,
+		RateLimit: rateLimit,
+		Backend:   progress.Backend,
+		RPCURL:    rpcURL,
+		APIKey:    apiKey,
+	}
+	client := NewAPIClient(config.RateLimit)
+	source, err := newChainSource(config, network, client)
+	if err != nil {
+		return 0, err
+	}
+
+	valid := 0
+	for address, info := range processAddressCreationBlocks(source, addresses) {
+		if info.Scannable() {
+			valid++
+		}
+		progress.Addresses[address] = info
+	}
+
+	reopen := completed && !scanComplete(progress)
+	switch {
+	case store != nil:
+		// Saving reopens a completed scan
+		err = store.SaveProgress(progress, addresses...)
+		if err == nil && completed && !reopen {
+			err = store.CompleteScan(scanID)
+		}
+	case completed && !reopen:
+		err = saveAddressProgress(getCompletedProgressFileName(scanID), progress)
+	default:
+		err = saveAddressProgress(progressFile, progress)
+		if err == nil && reopen {
+			err = removeProgressFile(getCompletedProgressFileName(scanID))
+		}
+	}
+	if err != nil {
+		return valid, err
+	}
+	if reopen {
+		progressf("Scan %s reopened\n", scanID)
+	}
+
+	progressf("\n")
+	printClassificationBreakdown(progressOutput{}, progress, "")
+	progressf("%d of %d address(es) are now valid and will be scanned when the scan is resumed\n", valid, len(addresses))
+	return valid, nil
+}
+
+// Helper function to get scan ID from partial ID, looking in the store when
+// one is given. Unfinished scans are matched before completed ones.
+func findScanByPartialID(partialID string, store *Store) string {
+	var ids []string
+	if store != nil {
+		var err error
+		if ids, err = store.Scans(); err != nil {
+			logger.Error("Failed to list scans", "store", store.path, "err", err)
+			return ""
+		}
+	} else {
+		ids = append(scanFileIDs("scan_progress_"), scanFileIDs("scan_completed_")...)
+	}
+
+	for _, id := range ids {
+		if strings.HasPrefix(id, partialID) {
+			return id
 		}
 	}
 	return ""
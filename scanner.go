@@ -0,0 +1,720 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FailedRange is a block range that could not be fetched for an address
+type FailedRange struct {
+	FromBlock   uint64    `json:"from_block"`
+	ToBlock     uint64    `json:"to_block"`
+	Attempts    int       `json:"attempts"`
+	LastError   string    `json:"last_error"`
+	LastAttempt time.Time `json:"last_attempt"`
+}
+
+// chunkResult summarizes one successfully scanned block range
+type chunkResult struct {
+	Logs          int
+	Duplicates    int
+	SmallestRange uint64
+	Split         bool
+	APIDuration   time.Duration
+
+	// Last transaction in the range that upgraded the proxy, and its block,
+	// carried over to the next range ("" = none so far)
+	LastUpgradeTx    string
+	LastUpgradeBlock uint64
+}
+
+// scanSession holds the state shared by every chunk of a scan run. Its
+// workers scan different addresses concurrently; mu guards the progress, the
+// counters, the caches and the writer.
+type scanSession struct {
+	mu sync.Mutex
+
+	config       ScannerConfig
+	network      NetworkConfig
+	client       *APIClient // the source's, shared by every worker
+	source       ChainSource
+	progress     *AddressProgress
+	progressFile string
+	writer       FindingsWriter
+
+	// Optional SQLite store; when set it holds the progress instead of the
+	// progress file
+	store *Store
+
+	// Alert sinks notified of new findings (nil = no alerts)
+	notifier *Notifier
+
+	// Detection rules, the topics they subscribe to and each topic's event kind
+	rules  []Rule
+	topics []string
+	kinds  map[string]string
+
+	// Resolved implementation chains by lower-case proxy address, and
+	// transaction senders by hash
+	chains     map[string][]string
+	senders    map[string]string
+	blockTimes map[uint64]time.Time
+
+	// Rule state of the proxies seen while watching all addresses, by
+	// lower-case address
+	proxyContexts map[string]*ruleContext
+
+	// Closed to stop watch mode; sweeps stop after their current chunk
+	done <-chan struct{}
+
+	totalAPITime time.Duration
+	requestCount int
+
+	// Whether the run tracks reorgs, and the findings it retracted because
+	// their blocks were reorged out
+	reorgTracking bool
+	retracted     int
+
+	// Address progress reporting
+	totalAddresses     int
+	completedAddresses int
+	started            time.Time
+}
+
+func newScanSession(config ScannerConfig, network NetworkConfig, client *APIClient, source ChainSource, progress *AddressProgress, progressFile string, writer FindingsWriter, store *Store) *scanSession {
+	if config.Event != nil {
+		registerEvent(*config.Event)
+	}
+	rules := backendRules(enabledRules(config), source.Name())
+	topics, kinds := subscribedTopics(config, rules)
+	return &scanSession{
+		started:      time.Now(),
+		rules:        rules,
+		topics:       topics,
+		kinds:        kinds,
+		config:       config,
+		network:      network,
+		client:       client,
+		source:       source,
+		progress:     progress,
+		progressFile: progressFile,
+		writer:       writer,
+		store:        store,
+		chains:       make(map[string][]string),
+		senders:      make(map[string]string),
+		blockTimes:   make(map[uint64]time.Time),
+
+		proxyContexts: make(map[string]*ruleContext),
+	}
+}
+
+// stopping reports whether watch mode has been asked to stop
+func (s *scanSession) stopping() bool {
+	select {
+	case <-s.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// workerCount returns how many workers to run for jobs jobs: the configured
+// concurrency (FallbackConcurrency if unset), at most one per job
+func workerCount(concurrency, jobs int) int {
+	if concurrency < 1 {
+		concurrency = FallbackConcurrency
+	}
+	if concurrency > jobs {
+		concurrency = jobs
+	}
+	return concurrency
+}
+
+// runWorkers calls fn with every index in pending on workers goroutines
+// (see workerCount) and waits for them to finish
+func runWorkers(pending []int, workers int, fn func(i int)) {
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				fn(i)
+			}
+		}()
+	}
+	for _, i := range pending {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+}
+
+// addressInfo returns the current progress of an address
+func (s *scanSession) addressInfo(address string) ContractInfo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.progress.Addresses[address]
+}
+
+// setAddress updates the progress of an address and checkpoints it
+func (s *scanSession) setAddress(address string, info ContractInfo) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.progress.Addresses[address] = info
+	s.save(address)
+}
+
+// save checkpoints the progress of an address. With a store only that
+// address's row is rewritten; otherwise the whole progress file is. The
+// caller must hold mu.
+func (s *scanSession) save(address string) {
+	if s.store == nil {
+		if err := saveAddressProgress(s.progressFile, *s.progress); err != nil {
+			logger.Error("Failed to save progress", "address", address, "err", err)
+		}
+		return
+	}
+	s.progress.LastUpdated = time.Now()
+	if err := s.store.SaveProgress(*s.progress, address); err != nil {
+		logger.Error("Failed to save progress", "address", address, "err", err)
+	}
+}
+
+// scanAddress sweeps one address in chunks from its creation block (or last
+// checkpoint) to endBlock, then retries its coverage gaps. Workers call it
+// concurrently for different addresses.
+func (s *scanSession) scanAddress(address string, index int, endBlock uint64) {
+	info := s.addressInfo(address)
+
+	// The sweep finished on a previous run; only the failed ranges are left
+	if info.SweepComplete {
+		progressf("\n📍 Address %d/%d: %s already swept, retrying coverage gaps\n", index, s.totalAddresses, address)
+		if s.retryFailedRanges(address) == 0 {
+			s.addressCompleted(address, 0, 0)
+		}
+		return
+	}
+
+	// Show progress (always visible regardless of log level)
+	s.mu.Lock()
+	remainingAddresses := s.totalAddresses - s.completedAddresses
+	s.mu.Unlock()
+	progressf("\n📍 Scanning address %d/%d (%d remaining): %s\n",
+		index, s.totalAddresses, remainingAddresses, address)
+	startBlock := info.CreationBlock
+	if startBlock == 0 {
+		startBlock = s.config.StartBlock
+	}
+
+	switch {
+	case info.LastScannedBlock > 0:
+		// Resume after the last checkpointed chunk
+		startBlock = info.LastScannedBlock + 1
+		logger.Info("Resuming address from checkpoint", "address", address, "from_block", startBlock)
+	case info.CreationBlock > 0:
+		logger.Info("Starting address from its creation block", "address", address, "from_block", startBlock)
+	default:
+		logger.Info("Creation block of address unknown, starting from the start block", "address", address, "from_block", startBlock)
+	}
+
+	info, addressLogs, addressDuplicates := s.sweep(address, info, startBlock, endBlock)
+
+	// Sweep done; the address is only complete once every range succeeded
+	info.SweepComplete = true
+	info.Processed = len(info.FailedRanges) == 0
+	s.setAddress(address, info)
+
+	if !info.Processed && s.retryFailedRanges(address) > 0 {
+		progressf("⚠️  Address %s incomplete: %d coverage gap(s) remain\n", address, len(s.addressInfo(address).FailedRanges))
+		return
+	}
+
+	s.addressCompleted(address, addressLogs, addressDuplicates)
+}
+
+// sweep scans an address in chunks over [startBlock, endBlock],
+// checkpointing every chunk and recording the ones that keep failing as
+// coverage gaps. It stops early when watch mode is stopping. Returns the
+// address's updated progress and the logs and duplicate transactions found.
+func (s *scanSession) sweep(address string, info ContractInfo, startBlock, endBlock uint64) (ContractInfo, int, int) {
+	addressLogs := 0
+	addressDuplicates := 0
+
+	ranges := newAdaptiveRange(s.config.BlockRange)
+	var toBlock uint64
+	for fromBlock := startBlock; fromBlock <= endBlock && !s.stopping(); fromBlock = toBlock + 1 {
+		toBlock = ranges.end(fromBlock, endBlock)
+
+		logger.Debug("Scanning blocks", "address", address, "from_block", fromBlock, "to_block", toBlock)
+
+		// Log all transactions in each block in this range (DEBUG level only)
+		if logEnabled(logger, slog.LevelDebug) && s.source.Name() == BackendBlockscout {
+			s.dumpBlockTransactions(fromBlock, toBlock)
+		}
+
+		// The API client has already retried the request; a range that still
+		// fails is recorded as a gap so it is retried instead of silently skipped
+		result, err := s.scanChunk(address, fromBlock, toBlock)
+		if err != nil {
+			logger.Warn("Failed to fetch logs, recorded as coverage gap", "address", address,
+				"from_block", fromBlock, "to_block", toBlock, "err", err)
+			progressf("⚠️  %s blocks %d-%d failed, recorded as coverage gap\n", address, fromBlock, toBlock)
+			info.recordFailure(fromBlock, toBlock, err)
+			info.LastScannedBlock = toBlock
+			s.setAddress(address, info)
+			continue
+		}
+		ranges.observe(result.Logs, result.SmallestRange, result.Split)
+		addressLogs += result.Logs
+		addressDuplicates += result.Duplicates
+
+		// Checkpoint the chunk so a restart resumes after it
+		info.LastScannedBlock = toBlock
+		info.LastUpgradeTx = result.LastUpgradeTx
+		info.LastUpgradeBlock = result.LastUpgradeBlock
+		s.setAddress(address, info)
+	}
+	return info, addressLogs, addressDuplicates
+}
+
+// addressCompleted counts a finished address and reports overall progress
+func (s *scanSession) addressCompleted(address string, logs, duplicates int) {
+	s.mu.Lock()
+	s.completedAddresses++
+	completed := s.completedAddresses
+	s.mu.Unlock()
+
+	remaining := s.totalAddresses - completed
+	overallProgress := float64(completed) / float64(s.totalAddresses) * 100
+
+	progressf("✅ Address %s complete: %d logs, %d duplicate transactions\n", address, logs, duplicates)
+	progressf("📊 Overall Progress: %d/%d (%.1f%%) | Remaining: %d addresses\n",
+		completed, s.totalAddresses, overallProgress, remaining)
+
+	// Estimate time remaining
+	if remaining > 0 {
+		elapsedSoFar := time.Since(s.started)
+		avgTimePerAddress := elapsedSoFar / time.Duration(completed)
+		estimatedTimeRemaining := avgTimePerAddress * time.Duration(remaining)
+		progressf("⏱️  Estimated time remaining: %v (avg: %v per address)\n",
+			estimatedTimeRemaining.Truncate(time.Second), avgTimePerAddress.Truncate(time.Second))
+	}
+}
+
+// scanChunk fetches the logs of every subscribed event of one address for
+// [fromBlock, toBlock] (of every address for allAddresses), evaluates the
+// detection rules on each transaction and writes the findings to the output
+// file
+func (s *scanSession) scanChunk(address string, fromBlock, toBlock uint64) (chunkResult, error) {
+	addresses := []string{address}
+	if address == allAddresses {
+		addresses = nil
+	}
+
+	var result chunkResult
+	// Collected per chunk and merged into the scan's stats afterwards, so
+	// workers don't share it while fetching
+	var stats RangeStats
+
+	// Measure API call time
+	apiStart := time.Now()
+	logs, smallestRange, err := fetchLogsAdaptive(s.source, s.topics, fromBlock, toBlock, addresses, &stats)
+	result.SmallestRange = smallestRange
+	result.APIDuration = time.Since(apiStart)
+	// One getLogs call per fetched sub-range plus one per capped page that was split
+	chunkRequests := stats.Requests + stats.Splits
+	if chunkRequests == 0 {
+		chunkRequests = 1
+	}
+
+	s.mu.Lock()
+	s.progress.RangeStats.merge(stats)
+	s.totalAPITime += result.APIDuration
+	s.requestCount += chunkRequests
+	if err == nil {
+		s.progress.TotalLogs += len(logs)
+		metricLogsFetched.Add(float64(len(logs)))
+	}
+	avgAPITime := s.totalAPITime / time.Duration(s.requestCount)
+	s.mu.Unlock()
+
+	if err != nil {
+		return result, err
+	}
+	result.Logs = len(logs)
+	result.Split = stats.Splits > 0
+
+	if s.store != nil {
+		if err := s.store.RecordChunk(s.progress.ScanID, address, fromBlock, toBlock, logs); err != nil {
+			logger.Error("Failed to store logs", "address", address, "from_block", fromBlock, "to_block", toBlock, "err", err)
+		}
+	}
+
+	// Log details about found events (DEBUG level only)
+	if len(logs) > 0 && logEnabled(detectorLogger, slog.LevelDebug) {
+		for _, logEntry := range logs {
+			kind := ""
+			if len(logEntry.Topics) > 0 {
+				kind = s.kinds[strings.ToLower(logEntry.Topics[0])]
+			}
+			detectorLogger.Debug("Found event", "event", kind, "tx", logEntry.TransactionHash,
+				"block", logEntry.BlockNumber, "address", logEntry.Address)
+		}
+	}
+
+	info := s.addressInfo(address)
+	ctx := &ruleContext{
+		info:             info,
+		lastUpgradeTx:    info.LastUpgradeTx,
+		lastUpgradeBlock: info.LastUpgradeBlock,
+	}
+
+	// Evaluate the rules on each transaction in chain order
+	for _, tx := range groupTransactions(logs, s.kinds) {
+		if address == allAddresses {
+			ctx = s.proxyContext(tx.Proxy)
+		}
+		s.resolveSenders(ctx, tx)
+		for _, rule := range s.rules {
+			if rule.Match(ctx, tx) {
+				if s.recordFinding(rule, tx) && rule.CountsDuplicates {
+					result.Duplicates++
+				}
+			}
+		}
+		if tx.Count(EventUpgraded) > 0 {
+			ctx.lastUpgradeTx = tx.TxHash
+			ctx.lastUpgradeFrom = tx.From
+			ctx.lastUpgradeBlock, _ = blockNumberOf(tx.Block)
+		}
+	}
+	result.LastUpgradeTx = ctx.lastUpgradeTx
+	result.LastUpgradeBlock = ctx.lastUpgradeBlock
+	s.mu.Lock()
+	if err := s.writer.Flush(); err != nil {
+		logger.Error("Failed to write findings", "file", s.config.OutputFile, "err", err)
+	}
+	s.mu.Unlock()
+
+	logger.Debug("Scanned blocks", "address", address, "from_block", fromBlock, "to_block", toBlock,
+		"logs", len(logs), "duplicates", result.Duplicates, "avg_api_time", avgAPITime.Truncate(time.Millisecond))
+
+	return result, nil
+}
+
+// recordFinding builds the finding of a matched rule and writes it to the
+// output file and store. Returns false if it was already recorded before a
+// restart.
+func (s *scanSession) recordFinding(rule Rule, tx *txEvents) bool {
+	changes := implementationChanges(tx.Logs)
+
+	blockNumber, err := blockNumberOf(tx.Block)
+	if err != nil {
+		detectorLogger.Error("Invalid block number", "block", tx.Block, "tx", tx.TxHash, "err", err)
+	}
+
+	finding := Finding{
+		ScanID:          s.progress.ScanID,
+		Network:         s.config.Network,
+		ChainID:         s.network.ChainID,
+		Rule:            rule.Name,
+		TxHash:          tx.TxHash,
+		ExplorerLink:    s.network.TxURL(tx.TxHash),
+		BlockNumber:     blockNumber,
+		Timestamp:       s.blockTime(tx, blockNumber),
+		Proxy:           tx.Proxy,
+		Implementations: changes,
+		Events:          decodeLogs(tx.Logs),
+	}
+
+	// Only show finding details in DEBUG mode
+	if logEnabled(detectorLogger, slog.LevelDebug) {
+		for _, event := range finding.Events {
+			detectorLogger.Debug("Rule matched", "rule", rule.Name, "tx", tx.TxHash, "block", blockNumber,
+				"address", tx.Proxy, "log_index", event.LogIndex, "event", event.String())
+		}
+	}
+
+	// Get transaction details
+	fromAddress, err := s.sender(tx.TxHash)
+	if err != nil {
+		detectorLogger.Error("Failed to get transaction details", "tx", tx.TxHash, "err", err)
+		fromAddress = "Unknown"
+	}
+	finding.From = fromAddress
+
+	// Follow the proxy's implementation to see whether it is a proxy itself
+	finding.ImplementationChain = s.implementationChain(finding.Proxy)
+	if finding.ImplementationChain == nil {
+		finding.ImplementationChain = []string{}
+	}
+	finding.Severity = severityOf(rule, finding.ImplementationChain)
+
+	if s.store != nil {
+		if err := s.store.SaveFinding(finding); err != nil {
+			logger.Error("Failed to store finding", "rule", rule.Name, "tx", tx.TxHash, "err", err)
+		}
+	}
+
+	if !s.addFinding(rule, finding) {
+		return false
+	}
+	// Queued outside the lock so a backed-up notifier can't stall the workers
+	s.notifier.Notify(finding)
+	return true
+}
+
+// addFinding writes a new finding to the output file and counts it. Returns
+// false if it was already recorded before a restart.
+func (s *scanSession) addFinding(rule Rule, finding Finding) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Write to the output file (findings recorded before a restart are skipped)
+	if !s.writer.Write(finding) {
+		detectorLogger.Debug("Finding already recorded, skipping", "rule", rule.Name, "tx", finding.TxHash)
+		return false
+	}
+
+	// Findings in tracked blocks are kept until those blocks are final
+	if blocks := s.progress.RecentBlocks; s.tracksReorgs() && len(blocks) > 0 && finding.BlockNumber >= blocks[0].Number {
+		s.progress.RecentFindings = append(s.progress.RecentFindings, finding)
+	}
+	if s.config.Watch {
+		progressf("🔔 %s finding (%s): proxy %s, tx %s, block %d\n",
+			rule.Name, finding.Severity, finding.Proxy, finding.TxHash, finding.BlockNumber)
+	}
+
+	if rule.CountsDuplicates {
+		s.progress.DuplicateTxs++
+	}
+	if s.progress.RuleFindings == nil {
+		s.progress.RuleFindings = make(map[string]int)
+	}
+	s.progress.RuleFindings[rule.Name]++
+	s.progress.ProcessedTxs++
+	metricFindings.Inc(rule.Name, finding.Severity)
+	if finding.Severity == SeverityHigh {
+		s.progress.HighSeverityTxs++
+		progressf("\n🚨 Nested proxy behind %s in tx %s: %s\n",
+			finding.Proxy, finding.TxHash, strings.Join(finding.ImplementationChain, " -> "))
+	}
+	return true
+}
+
+// blockTime returns the time of the transaction's block, from its logs when
+// the backend includes it, otherwise from the block header (cached for the
+// session). Returns nil if it can't be determined.
+func (s *scanSession) blockTime(tx *txEvents, block uint64) *time.Time {
+	for _, l := range tx.Logs {
+		for _, raw := range []string{l.TimeStamp, l.BlockTimestamp} {
+			if seconds, err := blockNumberOf(raw); err == nil && raw != "" {
+				t := time.Unix(int64(seconds), 0).UTC()
+				return &t
+			}
+		}
+	}
+
+	s.mu.Lock()
+	t, ok := s.blockTimes[block]
+	s.mu.Unlock()
+	if ok {
+		return &t
+	}
+
+	t, err := s.source.BlockTimestamp(block)
+	if err != nil {
+		logger.Error("Failed to get block timestamp", "block", block, "err", err)
+		return nil
+	}
+	s.mu.Lock()
+	s.blockTimes[block] = t
+	s.mu.Unlock()
+	return &t
+}
+
+// sender returns the sender of a transaction, cached for the session
+func (s *scanSession) sender(txHash string) (string, error) {
+	s.mu.Lock()
+	from, ok := s.senders[txHash]
+	s.mu.Unlock()
+	if ok {
+		return from, nil
+	}
+
+	from, err := s.source.TransactionFrom(txHash)
+	if err != nil {
+		return "", err
+	}
+	s.mu.Lock()
+	s.senders[txHash] = from
+	s.mu.Unlock()
+	return from, nil
+}
+
+// resolveSenders looks up, before the rules run, the senders they compare:
+// the transaction's when it has an event kind in a rule's SenderEvents, and
+// the proxy's last upgrade's. A sender that can't be fetched is left empty.
+func (s *scanSession) resolveSenders(ctx *ruleContext, tx *txEvents) {
+	needed := false
+	for _, rule := range s.rules {
+		for _, kind := range rule.SenderEvents {
+			needed = needed || tx.Count(kind) > 0
+		}
+	}
+	if !needed {
+		return
+	}
+
+	from, err := s.sender(tx.TxHash)
+	if err != nil {
+		detectorLogger.Error("Failed to get transaction sender", "tx", tx.TxHash, "err", err)
+	}
+	tx.From = from
+	if ctx.lastUpgradeTx != "" && ctx.lastUpgradeFrom == "" {
+		if ctx.lastUpgradeFrom, err = s.sender(ctx.lastUpgradeTx); err != nil {
+			detectorLogger.Error("Failed to get transaction sender", "tx", ctx.lastUpgradeTx, "err", err)
+		}
+	}
+}
+
+// recordFailure adds (or updates) a coverage gap for the address
+func (info *ContractInfo) recordFailure(fromBlock, toBlock uint64, err error) {
+	for i, gap := range info.FailedRanges {
+		if gap.FromBlock == fromBlock && gap.ToBlock == toBlock {
+			info.FailedRanges[i].Attempts++
+			info.FailedRanges[i].LastError = err.Error()
+			info.FailedRanges[i].LastAttempt = time.Now()
+			return
+		}
+	}
+	info.FailedRanges = append(info.FailedRanges, FailedRange{
+		FromBlock:   fromBlock,
+		ToBlock:     toBlock,
+		Attempts:    1,
+		LastError:   err.Error(),
+		LastAttempt: time.Now(),
+	})
+}
+
+// retryFailedRanges retries every coverage gap of an address, removing the
+// ones that now succeed. The address is marked processed once its sweep is
+// complete and no gaps remain. Returns the number of gaps still open.
+func (s *scanSession) retryFailedRanges(address string) int {
+	info := s.addressInfo(address)
+	if len(info.FailedRanges) == 0 {
+		return 0
+	}
+
+	progressf("🔁 Retrying %d failed range(s) for %s\n", len(info.FailedRanges), address)
+
+	var remaining []FailedRange
+	for _, gap := range info.FailedRanges {
+		metricChunkRetries.Inc()
+		result, err := s.scanChunk(address, gap.FromBlock, gap.ToBlock)
+		if err != nil {
+			logger.Warn("Coverage gap still failing", "address", address,
+				"from_block", gap.FromBlock, "to_block", gap.ToBlock, "attempts", gap.Attempts+1, "err", err)
+			gap.Attempts++
+			gap.LastError = err.Error()
+			gap.LastAttempt = time.Now()
+			remaining = append(remaining, gap)
+			continue
+		}
+		progressf("   ✅ %s blocks %d-%d recovered: %d logs, %d duplicate transactions\n",
+			address, gap.FromBlock, gap.ToBlock, result.Logs, result.Duplicates)
+	}
+
+	info.FailedRanges = remaining
+	info.Processed = info.SweepComplete && len(remaining) == 0
+	s.setAddress(address, info)
+
+	return len(remaining)
+}
+
+// countCoverageGaps returns the number of failed ranges across all addresses
+func countCoverageGaps(progress AddressProgress) int {
+	gaps := 0
+	for _, info := range progress.Addresses {
+		gaps += len(info.FailedRanges)
+	}
+	return gaps
+}
+
+// dumpBlockTransactions logs all transactions in each block in the range,
+// with their decoded events (debug level only, Blockscout backend only)
+func (s *scanSession) dumpBlockTransactions(fromBlock, toBlock uint64) {
+	for blockNum := fromBlock; blockNum <= toBlock; blockNum++ {
+		txHashes, err := getBlockTransactions(s.client, s.network.BlockscoutURL, blockNum)
+		if err != nil {
+			logger.Debug("Failed to fetch block transactions", "block", blockNum, "err", err)
+			continue
+		}
+		logger.Debug("Block transactions", "block", blockNum, "transactions", len(txHashes))
+
+		for _, txHash := range txHashes {
+			// Fetch and log the events of this transaction
+			logs, err := getTransactionLogs(s.client, s.network.BlockscoutURL, txHash)
+			if err != nil {
+				logger.Debug("Failed to fetch transaction logs", "block", blockNum, "tx", txHash, "err", err)
+				continue
+			}
+			logger.Debug("Block transaction", "block", blockNum, "tx", txHash, "logs", len(logs))
+
+			for j, logEntry := range logs {
+				// Extract key fields from the log
+				address := ""
+				topics := []interface{}{}
+				data := ""
+				decoded := map[string]interface{}{}
+
+				if addr, ok := logEntry["address"].(map[string]interface{}); ok {
+					if hash, exists := addr["hash"].(string); exists {
+						address = hash
+					}
+				}
+				if topicsArray, ok := logEntry["topics"].([]interface{}); ok {
+					topics = topicsArray
+				}
+				if dataStr, ok := logEntry["data"].(string); ok {
+					data = dataStr
+				}
+				if decodedData, ok := logEntry["decoded"].(map[string]interface{}); ok {
+					decoded = decodedData
+				}
+
+				attrs := []any{"block", blockNum, "tx", txHash, "log", j + 1, "address", address}
+
+				// Show decoded event information if available
+				if methodCall, exists := decoded["method_call"].(string); exists {
+					attrs = append(attrs, "event", methodCall)
+					if params, exists := decoded["parameters"].([]interface{}); exists {
+						var parameters []string
+						for _, param := range params {
+							if paramMap, ok := param.(map[string]interface{}); ok {
+								parameters = append(parameters, fmt.Sprintf("%v (%v, indexed:%v) = %v",
+									paramMap["name"], paramMap["type"], paramMap["indexed"], paramMap["value"]))
+							}
+						}
+						attrs = append(attrs, "parameters", parameters)
+					}
+				} else {
+					// Fallback to raw topic display
+					attrs = append(attrs, "topics", topics)
+				}
+
+				if len(data) > 100 {
+					attrs = append(attrs, "data", fmt.Sprintf("%s... (%d chars)", data[:100], len(data)))
+				} else if data != "" && data != "0x" {
+					attrs = append(attrs, "data", data)
+				}
+				logger.Debug("Transaction log", attrs...)
+			}
+		}
+	}
+}
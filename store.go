@@ -0,0 +1,540 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// storeSchemaVersion is recorded in the database's user_version; bump it and
+// add a step to storeMigrations when the schema changes
+const storeSchemaVersion = 5
+
+// storeSchema creates the tables of a new store. Scans and addresses mirror
+// AddressProgress and ContractInfo; ranges, logs and findings are only ever
+// appended, so they can be queried across every scan.
+const storeSchema = `
+CREATE TABLE IF NOT EXISTS scans (
+	scan_id           TEXT PRIMARY KEY,
+	network           TEXT NOT NULL,
+	backend           TEXT NOT NULL DEFAULT '',
+	event_topic       TEXT NOT NULL,
+	rules             TEXT NOT NULL DEFAULT '',
+	output_file       TEXT NOT NULL DEFAULT '',
+	output_format     TEXT NOT NULL DEFAULT '',
+	total_logs        INTEGER NOT NULL DEFAULT 0,
+	duplicate_txs     INTEGER NOT NULL DEFAULT 0,
+	processed_txs     INTEGER NOT NULL DEFAULT 0,
+	high_severity_txs INTEGER NOT NULL DEFAULT 0,
+	rule_findings     TEXT NOT NULL DEFAULT '{}',
+	range_stats       TEXT NOT NULL DEFAULT '{}',
+	head_block        INTEGER NOT NULL DEFAULT 0,
+	recent_blocks     TEXT NOT NULL DEFAULT '[]',
+	recent_findings   TEXT NOT NULL DEFAULT '[]',
+	started_at        TIMESTAMP NOT NULL,
+	updated_at        TIMESTAMP NOT NULL,
+	completed_at      TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS addresses (
+	scan_id            TEXT NOT NULL REFERENCES scans(scan_id) ON DELETE CASCADE,
+	address            TEXT NOT NULL,
+	creation_block     INTEGER NOT NULL DEFAULT 0,
+	creation_tx        TEXT NOT NULL DEFAULT '',
+	processed          BOOLEAN NOT NULL DEFAULT 0,
+	sweep_complete     BOOLEAN NOT NULL DEFAULT 0,
+	last_scanned_block INTEGER NOT NULL DEFAULT 0,
+	last_upgrade_tx    TEXT NOT NULL DEFAULT '',
+	last_upgrade_block INTEGER NOT NULL DEFAULT 0,
+	failed_ranges      TEXT NOT NULL DEFAULT '[]',
+	status             TEXT NOT NULL DEFAULT '',
+	status_error       TEXT NOT NULL DEFAULT '',
+	PRIMARY KEY (scan_id, address)
+);
+
+CREATE TABLE IF NOT EXISTS scanned_ranges (
+	scan_id    TEXT NOT NULL REFERENCES scans(scan_id) ON DELETE CASCADE,
+	address    TEXT NOT NULL,
+	from_block INTEGER NOT NULL,
+	to_block   INTEGER NOT NULL,
+	log_count  INTEGER NOT NULL,
+	scanned_at TIMESTAMP NOT NULL,
+	PRIMARY KEY (scan_id, address, from_block, to_block)
+);
+
+CREATE TABLE IF NOT EXISTS logs (
+	scan_id      TEXT NOT NULL REFERENCES scans(scan_id) ON DELETE CASCADE,
+	tx_hash      TEXT NOT NULL,
+	log_index    INTEGER NOT NULL,
+	block_number INTEGER NOT NULL,
+	address      TEXT NOT NULL,
+	topic0       TEXT NOT NULL,
+	topics       TEXT NOT NULL,
+	data         TEXT NOT NULL,
+	PRIMARY KEY (scan_id, tx_hash, log_index, address, topic0)
+);
+CREATE INDEX IF NOT EXISTS logs_address ON logs (address, topic0);
+
+CREATE TABLE IF NOT EXISTS findings (
+	scan_id      TEXT NOT NULL REFERENCES scans(scan_id) ON DELETE CASCADE,
+	tx_hash      TEXT NOT NULL,
+	proxy        TEXT NOT NULL,
+	rule         TEXT NOT NULL,
+	severity     TEXT NOT NULL,
+	network      TEXT NOT NULL,
+	chain_id     INTEGER NOT NULL,
+	block_number INTEGER NOT NULL,
+	timestamp    TIMESTAMP,
+	from_address TEXT NOT NULL,
+	record       TEXT NOT NULL,
+	found_at     TIMESTAMP NOT NULL,
+	retracted_at TIMESTAMP,
+	PRIMARY KEY (scan_id, tx_hash, proxy, rule)
+);
+CREATE INDEX IF NOT EXISTS findings_proxy ON findings (proxy);
+CREATE INDEX IF NOT EXISTS findings_rule ON findings (rule, timestamp);
+`
+
+// storeMigrations[i] upgrades a store from schema version i+1 to i+2; new
+// stores are created from storeSchema directly
+var storeMigrations = []string{
+	// 2: address classifications
+	`ALTER TABLE addresses ADD COLUMN status TEXT NOT NULL DEFAULT '';
+	ALTER TABLE addresses ADD COLUMN status_error TEXT NOT NULL DEFAULT '';`,
+	// 3: watch mode head cursor
+	`ALTER TABLE scans ADD COLUMN head_block INTEGER NOT NULL DEFAULT 0;`,
+	// 4: reorg tracking
+	`ALTER TABLE scans ADD COLUMN recent_blocks TEXT NOT NULL DEFAULT '[]';
+	ALTER TABLE scans ADD COLUMN recent_findings TEXT NOT NULL DEFAULT '[]';
+	ALTER TABLE findings ADD COLUMN retracted_at TIMESTAMP;`,
+	// 5: block of the last upgrade, for reorg rollback
+	`ALTER TABLE addresses ADD COLUMN last_upgrade_block INTEGER NOT NULL DEFAULT 0;`,
+}
+
+// Store is an SQLite database holding scan progress, raw logs and findings
+type Store struct {
+	db   *sql.DB
+	path string
+}
+
+// OpenStore opens (creating if needed) the SQLite store at path
+func OpenStore(path string) (*Store, error) {
+	db, err := sql.Open("sqlite3", "file:"+path+"?_busy_timeout=5000&_journal_mode=WAL&_foreign_keys=on")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open store %s: %v", path, err)
+	}
+	// SQLite allows a single writer; one connection avoids lock contention
+	db.SetMaxOpenConns(1)
+
+	s := &Store{db: db, path: path}
+	if err := s.migrate(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to initialize store %s: %v", path, err)
+	}
+	return s, nil
+}
+
+// migrate brings the schema up to storeSchemaVersion
+func (s *Store) migrate() error {
+	var version int
+	if err := s.db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		return err
+	}
+	if version > storeSchemaVersion {
+		return fmt.Errorf("store schema version %d is newer than supported version %d", version, storeSchemaVersion)
+	}
+	if version == storeSchemaVersion {
+		return nil
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if version == 0 {
+		if _, err := tx.Exec(storeSchema); err != nil {
+			return err
+		}
+	} else {
+		for v := version; v < storeSchemaVersion; v++ {
+			if _, err := tx.Exec(storeMigrations[v-1]); err != nil {
+				return fmt.Errorf("migration to schema version %d failed: %v", v+1, err)
+			}
+		}
+	}
+	if _, err := tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", storeSchemaVersion)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// SaveProgress writes the scan's counters and the given addresses' state
+// (every address when none are given) in one transaction
+func (s *Store) SaveProgress(progress AddressProgress, addresses ...string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := saveScanRow(tx, progress); err != nil {
+		return err
+	}
+	if err := saveAddresses(tx, progress, addresses); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// saveAddresses writes the state of the given addresses (all of them if
+// none are given) within tx
+func saveAddresses(tx *sql.Tx, progress AddressProgress, addresses []string) error {
+	if len(addresses) == 0 {
+		for address := range progress.Addresses {
+			addresses = append(addresses, address)
+		}
+	}
+	for _, address := range addresses {
+		info := progress.Addresses[address]
+		failedRanges, _ := json.Marshal(info.FailedRanges)
+		_, err := tx.Exec(`
+			INSERT OR REPLACE INTO addresses (scan_id, address, creation_block, creation_tx, processed,
+				sweep_complete, last_scanned_block, last_upgrade_tx, last_upgrade_block, failed_ranges,
+				status, status_error)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			progress.ScanID, address, info.CreationBlock, info.CreationTx, info.Processed,
+			info.SweepComplete, info.LastScannedBlock, info.LastUpgradeTx, info.LastUpgradeBlock,
+			string(failedRanges), info.Status, info.StatusError)
+		if err != nil {
+			return fmt.Errorf("failed to save address %s: %v", address, err)
+		}
+	}
+	return nil
+}
+
+// SaveScan writes the scan's counters and head cursor without touching its
+// addresses
+func (s *Store) SaveScan(progress AddressProgress) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := saveScanRow(tx, progress); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// saveScanRow inserts or updates the scans row of progress, reopening the
+// scan if it was completed
+func saveScanRow(tx *sql.Tx, progress AddressProgress) error {
+	ruleFindings, _ := json.Marshal(progress.RuleFindings)
+	rangeStats, _ := json.Marshal(progress.RangeStats)
+	recentBlocks, _ := json.Marshal(progress.RecentBlocks)
+	recentFindings, _ := json.Marshal(progress.RecentFindings)
+	now := time.Now().UTC()
+	_, err := tx.Exec(`
+		INSERT INTO scans (scan_id, network, backend, event_topic, rules, output_file, output_format,
+			total_logs, duplicate_txs, processed_txs, high_severity_txs, rule_findings, range_stats,
+			head_block, recent_blocks, recent_findings, started_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (scan_id) DO UPDATE SET
+			backend = excluded.backend, output_file = excluded.output_file,
+			output_format = excluded.output_format, total_logs = excluded.total_logs,
+			duplicate_txs = excluded.duplicate_txs, processed_txs = excluded.processed_txs,
+			high_severity_txs = excluded.high_severity_txs, rule_findings = excluded.rule_findings,
+			range_stats = excluded.range_stats, head_block = excluded.head_block,
+			recent_blocks = excluded.recent_blocks, recent_findings = excluded.recent_findings,
+			updated_at = excluded.updated_at, completed_at = NULL`,
+		progress.ScanID, progress.Network, progress.Backend, progress.EventTopic,
+		strings.Join(progress.Rules, ","), progress.OutputFile, progress.OutputFormat,
+		progress.TotalLogs, progress.DuplicateTxs, progress.ProcessedTxs, progress.HighSeverityTxs,
+		string(ruleFindings), string(rangeStats), progress.HeadBlock, string(recentBlocks),
+		string(recentFindings), now, now)
+	if err != nil {
+		return fmt.Errorf("failed to save scan %s: %v", progress.ScanID, err)
+	}
+	return nil
+}
+
+// StartScan records a fresh run of a scan, discarding the address state of
+// a previous run with the same ID. Its logs and findings are kept.
+func (s *Store) StartScan(progress AddressProgress) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM addresses WHERE scan_id = ?`, progress.ScanID); err != nil {
+		return fmt.Errorf("failed to reset scan %s: %v", progress.ScanID, err)
+	}
+	if err := saveScanRow(tx, progress); err != nil {
+		return err
+	}
+	if err := saveAddresses(tx, progress, nil); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// LoadProgress returns the progress of an unfinished scan. The returned
+// progress has an empty ScanID if the store has no such scan.
+func (s *Store) LoadProgress(scanID string) (AddressProgress, error) {
+	return s.loadScan(scanID, false)
+}
+
+// LoadCompletedScan returns the progress a completed scan was left with.
+// The returned progress has an empty ScanID if the store has no such scan.
+func (s *Store) LoadCompletedScan(scanID string) (AddressProgress, error) {
+	return s.loadScan(scanID, true)
+}
+
+func (s *Store) loadScan(scanID string, completed bool) (AddressProgress, error) {
+	progress := AddressProgress{Addresses: make(map[string]ContractInfo)}
+	state := "completed_at IS NULL"
+	if completed {
+		state = "completed_at IS NOT NULL"
+	}
+
+	var rules, ruleFindings, rangeStats, recentBlocks, recentFindings string
+	err := s.db.QueryRow(`
+		SELECT scan_id, network, backend, event_topic, rules, output_file, output_format,
+			total_logs, duplicate_txs, processed_txs, high_severity_txs, rule_findings, range_stats,
+			head_block, recent_blocks, recent_findings, updated_at
+		FROM scans WHERE scan_id = ? AND `+state, scanID).Scan(
+		&progress.ScanID, &progress.Network, &progress.Backend, &progress.EventTopic, &rules,
+		&progress.OutputFile, &progress.OutputFormat, &progress.TotalLogs, &progress.DuplicateTxs,
+		&progress.ProcessedTxs, &progress.HighSeverityTxs, &ruleFindings, &rangeStats,
+		&progress.HeadBlock, &recentBlocks, &recentFindings, &progress.LastUpdated)
+	if err == sql.ErrNoRows {
+		return progress, nil
+	}
+	if err != nil {
+		return progress, fmt.Errorf("failed to load scan %s: %v", scanID, err)
+	}
+	if rules != "" {
+		progress.Rules = strings.Split(rules, ",")
+	}
+	if err := json.Unmarshal([]byte(ruleFindings), &progress.RuleFindings); err != nil {
+		return progress, fmt.Errorf("invalid rule_findings for scan %s: %v", scanID, err)
+	}
+	if err := json.Unmarshal([]byte(rangeStats), &progress.RangeStats); err != nil {
+		return progress, fmt.Errorf("invalid range_stats for scan %s: %v", scanID, err)
+	}
+	if err := json.Unmarshal([]byte(recentBlocks), &progress.RecentBlocks); err != nil {
+		return progress, fmt.Errorf("invalid recent_blocks for scan %s: %v", scanID, err)
+	}
+	if err := json.Unmarshal([]byte(recentFindings), &progress.RecentFindings); err != nil {
+		return progress, fmt.Errorf("invalid recent_findings for scan %s: %v", scanID, err)
+	}
+
+	rows, err := s.db.Query(`
+		SELECT address, creation_block, creation_tx, processed, sweep_complete,
+			last_scanned_block, last_upgrade_tx, last_upgrade_block, failed_ranges, status, status_error
+		FROM addresses WHERE scan_id = ?`, scanID)
+	if err != nil {
+		return progress, fmt.Errorf("failed to load addresses of scan %s: %v", scanID, err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var info ContractInfo
+		var failedRanges string
+		if err := rows.Scan(&info.Address, &info.CreationBlock, &info.CreationTx, &info.Processed,
+			&info.SweepComplete, &info.LastScannedBlock, &info.LastUpgradeTx, &info.LastUpgradeBlock, &failedRanges,
+			&info.Status, &info.StatusError); err != nil {
+			return progress, err
+		}
+		if err := json.Unmarshal([]byte(failedRanges), &info.FailedRanges); err != nil {
+			return progress, fmt.Errorf("invalid failed_ranges for %s: %v", info.Address, err)
+		}
+		progress.Addresses[info.Address] = info
+	}
+	return progress, rows.Err()
+}
+
+// CompleteScan marks a scan as finished; it is no longer resumed but its
+// history stays queryable
+func (s *Store) CompleteScan(scanID string) error {
+	_, err := s.db.Exec(`UPDATE scans SET completed_at = ? WHERE scan_id = ?`, time.Now().UTC(), scanID)
+	return err
+}
+
+// DeleteScan removes a scan; its addresses, ranges, logs and findings go
+// with it through ON DELETE CASCADE. Returns false if the store has no such
+// scan.
+func (s *Store) DeleteScan(scanID string) (bool, error) {
+	result, err := s.db.Exec(`DELETE FROM scans WHERE scan_id = ?`, scanID)
+	if err != nil {
+		return false, fmt.Errorf("failed to delete scan %s: %v", scanID, err)
+	}
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return deleted > 0, nil
+}
+
+// StaleScans returns the IDs of the unfinished scans last updated before
+// cutoff
+func (s *Store) StaleScans(cutoff time.Time) ([]string, error) {
+	rows, err := s.db.Query(`SELECT scan_id, updated_at FROM scans WHERE completed_at IS NULL`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		var updated time.Time
+		if err := rows.Scan(&id, &updated); err != nil {
+			return nil, err
+		}
+		if updated.Before(cutoff) {
+			ids = append(ids, id)
+		}
+	}
+	return ids, rows.Err()
+}
+
+// Scans returns the IDs of every scan, the unfinished ones first, each most
+// recent first
+func (s *Store) Scans() ([]string, error) {
+	rows, err := s.db.Query(`SELECT scan_id FROM scans ORDER BY completed_at IS NOT NULL, updated_at DESC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// ActiveScans returns the IDs of the unfinished scans, most recent first
+func (s *Store) ActiveScans() ([]string, error) {
+	rows, err := s.db.Query(`SELECT scan_id FROM scans WHERE completed_at IS NULL ORDER BY updated_at DESC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// RecordChunk stores the raw logs of a scanned block range and marks the
+// range as scanned for the address
+func (s *Store) RecordChunk(scanID, address string, fromBlock, toBlock uint64, logs []LogEntry) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, l := range logs {
+		index, _ := l.Index()
+		block, _ := blockNumberOf(l.BlockNumber)
+		topic0 := ""
+		if len(l.Topics) > 0 {
+			topic0 = strings.ToLower(l.Topics[0])
+		}
+		topics, _ := json.Marshal(l.Topics)
+		_, err := tx.Exec(`
+			INSERT OR IGNORE INTO logs (scan_id, tx_hash, log_index, block_number, address, topic0, topics, data)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+			scanID, l.TransactionHash, index, block, strings.ToLower(l.Address), topic0, string(topics), l.Data)
+		if err != nil {
+			return fmt.Errorf("failed to store log of tx %s: %v", l.TransactionHash, err)
+		}
+	}
+
+	_, err = tx.Exec(`
+		INSERT OR REPLACE INTO scanned_ranges (scan_id, address, from_block, to_block, log_count, scanned_at)
+		VALUES (?, ?, ?, ?, ?, ?)`,
+		scanID, address, fromBlock, toBlock, len(logs), time.Now().UTC())
+	if err != nil {
+		return fmt.Errorf("failed to record range %d-%d: %v", fromBlock, toBlock, err)
+	}
+
+	return tx.Commit()
+}
+
+// SaveFinding stores a finding with its full JSON record. A finding already
+// stored for the scan is left unchanged, unless it was retracted: it is then
+// restored with the block it was found in again.
+func (s *Store) SaveFinding(finding Finding) error {
+	record, err := json.Marshal(findingRecord{SchemaVersion: FindingsSchemaVersion, Finding: finding})
+	if err != nil {
+		return err
+	}
+	var timestamp interface{}
+	if finding.Timestamp != nil {
+		timestamp = finding.Timestamp.UTC()
+	}
+	_, err = s.db.Exec(`
+		INSERT INTO findings (scan_id, tx_hash, proxy, rule, severity, network, chain_id,
+			block_number, timestamp, from_address, record, found_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (scan_id, tx_hash, proxy, rule) DO UPDATE SET
+			block_number = excluded.block_number, timestamp = excluded.timestamp,
+			record = excluded.record, found_at = excluded.found_at, retracted_at = NULL
+		WHERE findings.retracted_at IS NOT NULL`,
+		finding.ScanID, strings.ToLower(finding.TxHash), strings.ToLower(finding.Proxy), finding.Rule,
+		finding.Severity, finding.Network, finding.ChainID, finding.BlockNumber, timestamp,
+		finding.From, string(record), time.Now().UTC())
+	return err
+}
+
+// RollBack undoes a scan's results above fork, whose blocks were reorged
+// out: findings there are marked retracted, their raw logs are deleted and
+// scanned ranges are cut back to the fork
+func (s *Store) RollBack(scanID string, fork uint64) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	steps := []struct {
+		query string
+		args  []interface{}
+	}{
+		{`UPDATE findings SET retracted_at = ? WHERE scan_id = ? AND block_number > ? AND retracted_at IS NULL`,
+			[]interface{}{time.Now().UTC(), scanID, fork}},
+		{`DELETE FROM logs WHERE scan_id = ? AND block_number > ?`, []interface{}{scanID, fork}},
+		{`DELETE FROM scanned_ranges WHERE scan_id = ? AND from_block > ?`, []interface{}{scanID, fork}},
+		{`UPDATE OR REPLACE scanned_ranges SET to_block = ? WHERE scan_id = ? AND to_block > ?`,
+			[]interface{}{fork, scanID, fork}},
+	}
+	for _, step := range steps {
+		if _, err := tx.Exec(step.query, step.args...); err != nil {
+			return fmt.Errorf("failed to roll back scan %s to block %d: %v", scanID, fork, err)
+		}
+	}
+	return tx.Commit()
+}
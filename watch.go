@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// allAddresses is the address key under which chunks covering every address
+// are scanned and recorded, when watching without target addresses
+const allAddresses = ""
+
+// proxyContext returns the rule state of a proxy seen while watching all
+// addresses. Its creation transaction is unknown, and earlier upgrades are
+// only known from the blocks watched since the watcher started.
+func (s *scanSession) proxyContext(proxy string) *ruleContext {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := strings.ToLower(proxy)
+	ctx, ok := s.proxyContexts[key]
+	if !ok {
+		ctx = &ruleContext{info: ContractInfo{Address: proxy}}
+		s.proxyContexts[key] = ctx
+	}
+	return ctx
+}
+
+// saveHead records that the scan has followed the chain through head
+func (s *scanSession) saveHead(head uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.progress.HeadBlock = head
+	s.progress.LastUpdated = time.Now()
+	if s.store == nil {
+		if err := saveAddressProgress(s.progressFile, *s.progress); err != nil {
+			logger.Error("Failed to save progress", "head_block", head, "err", err)
+		}
+		return
+	}
+	if err := s.store.SaveScan(*s.progress); err != nil {
+		logger.Error("Failed to save progress", "head_block", head, "err", err)
+	}
+}
+
+// watchStartBlock returns the block a watch of all addresses has covered so
+// far: its saved head cursor, the block before -start-block, or the current
+// head when neither is set
+func watchStartBlock(progress AddressProgress, config ScannerConfig, latestBlock uint64) uint64 {
+	switch {
+	case progress.HeadBlock > 0:
+		return progress.HeadBlock
+	case config.StartBlock > 0:
+		return config.StartBlock - 1
+	default:
+		return latestBlock
+	}
+}
+
+// watch follows the chain head once the scan has covered every block up to
+// head: every PollInterval it scans the blocks mined since the previous
+// round that have the configured confirmations, for each target address or,
+// without targets, for all addresses. Findings are written (and reported) as
+// they are made, and the head cursor is saved after every round so a
+// restarted watch resumes where it stopped. Blocks replaced by a reorg are
+// rolled back and scanned again (see checkReorgs). Returns on SIGINT or
+// SIGTERM.
+func (s *scanSession) watch(addresses []string, head uint64) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	s.done = ctx.Done()
+
+	progressf("\n👀 Watching %s for new blocks every %v from block %d, %d confirmation(s) (Ctrl-C to stop)\n",
+		s.network.Name, s.config.PollInterval, head+1, s.config.Confirmations)
+	if len(addresses) == 0 {
+		progressf("   No target addresses: watching every address\n")
+	}
+
+	for !s.stopping() {
+		latest, err := s.source.LatestBlockNumber()
+		if err != nil {
+			httpLogger.Error("Failed to get latest block number", "err", err)
+		} else {
+			metricChainHead.Set(float64(latest))
+			latest = confirmedHead(latest, s.config.Confirmations)
+			if fork, reorged := s.checkReorgs(latest); reorged && fork < head {
+				head = fork
+			}
+		}
+		if err == nil && latest > head {
+			logger.Info("New blocks", "from_block", head+1, "to_block", latest)
+			if len(addresses) == 0 {
+				head = s.followAllAddresses(head, latest)
+			} else if s.followAddresses(addresses, latest) {
+				head = latest
+				s.saveHead(head)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+		case <-time.After(s.config.PollInterval):
+		}
+	}
+	progressf("\n🛑 Watch stopped at block %d\n", head)
+}
+
+// followAddresses scans every target address up to latest, from the block
+// after its last checkpoint, retrying its coverage gaps first. Returns false
+// if the round was interrupted.
+func (s *scanSession) followAddresses(addresses []string, latest uint64) bool {
+	pending := make([]int, len(addresses))
+	for i := range addresses {
+		pending[i] = i
+	}
+	runWorkers(pending, workerCount(s.config.Concurrency, len(pending)), func(i int) {
+		if s.stopping() {
+			return
+		}
+		address := addresses[i]
+		if len(s.addressInfo(address).FailedRanges) > 0 {
+			s.retryFailedRanges(address)
+		}
+
+		info := s.addressInfo(address)
+		fromBlock := info.LastScannedBlock + 1
+		if info.LastScannedBlock == 0 {
+			fromBlock = info.CreationBlock
+			if fromBlock == 0 {
+				fromBlock = s.config.StartBlock
+			}
+		}
+		if fromBlock > latest {
+			return
+		}
+		info, logs, _ := s.sweep(address, info, fromBlock, latest)
+		if !s.stopping() {
+			info.SweepComplete = true
+			info.Processed = len(info.FailedRanges) == 0
+			s.setAddress(address, info)
+		}
+		if logs > 0 {
+			logger.Info("Found logs", "address", address, "from_block", fromBlock, "to_block", latest, "logs", logs)
+		}
+	})
+	return !s.stopping()
+}
+
+// followAllAddresses scans (head, latest] for every address, saving the
+// head cursor after each chunk. A chunk that keeps failing is retried on the
+// next round rather than recorded as a gap. Returns the new head.
+func (s *scanSession) followAllAddresses(head, latest uint64) uint64 {
+	ranges := newAdaptiveRange(s.config.BlockRange)
+	for fromBlock := head + 1; fromBlock <= latest && !s.stopping(); fromBlock = head + 1 {
+		toBlock := ranges.end(fromBlock, latest)
+		result, err := s.scanChunk(allAddresses, fromBlock, toBlock)
+		if err != nil {
+			logger.Error("Blocks failed, retrying them next round", "from_block", fromBlock, "to_block", toBlock, "err", err)
+			return head
+		}
+		ranges.observe(result.Logs, result.SmallestRange, result.Split)
+		if result.Logs > 0 {
+			logger.Info("Found logs", "from_block", fromBlock, "to_block", toBlock, "logs", result.Logs)
+		}
+		head = toBlock
+		s.saveHead(head)
+	}
+	return head
+}